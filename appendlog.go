@@ -0,0 +1,320 @@
+package gostore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// SyncPolicy controls how often the append-only log is flushed to disk
+type SyncPolicy int
+
+const (
+	// SyncEverySecond flushes the log to disk once per second
+	SyncEverySecond SyncPolicy = iota
+
+	// SyncAlways flushes the log to disk after every write
+	SyncAlways
+
+	// SyncNever leaves flushing to the operating system
+	SyncNever
+)
+
+// frameHeaderSize is the size of the length and checksum preceding each record
+const frameHeaderSize = 8
+
+// appendLog is an append-only log of the mutations applied to the store.
+// Each record is written as a frame of a 4 byte length, a 4 byte CRC32 of the
+// payload and the gob encoded payload, so a torn write at the end of the log
+// can be detected and discarded on replay.
+type appendLog struct {
+	mu          sync.Mutex
+	path        string
+	f           *os.File
+	policy      SyncPolicy
+	compactSize int64
+	size        int64
+	lastRewrite int64
+	dirty       bool
+	rewriting   bool
+	capturing   bool // whether appended frames are kept for the rewrite
+	closed      bool
+	rewriteBuf  [][]byte
+	rewritten   *sync.Cond
+	dump        func(start func()) ([]record, error)
+	close       chan bool
+	logger      Logger
+}
+
+// openAppendLog opens the log at path, creating it if it does not exist, and
+// replays it into st. An incomplete record or one with a bad checksum at the
+// end of the log is truncated away.
+func openAppendLog(path string, policy SyncPolicy, compactSize int64, st *storeState, logger Logger) (*appendLog, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	size, err := replayLog(f, st)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.Size() != size {
+		logger.Printf("WARNING: truncating %d bytes of incomplete records from %s", fi.Size()-size, path)
+		if err := f.Truncate(size); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	l := &appendLog{
+		path:        path,
+		f:           f,
+		policy:      policy,
+		compactSize: compactSize,
+		size:        size,
+		lastRewrite: size,
+		close:       make(chan bool),
+		logger:      logger,
+	}
+	l.rewritten = sync.NewCond(&l.mu)
+	if policy == SyncEverySecond {
+		go l.syncLoop()
+	}
+	return l, nil
+}
+
+// replayLog applies the records in r to st and returns the offset of the end
+// of the last valid record. A short read or a checksum mismatch ends the
+// replay, but a record that has a valid checksum and cannot be decoded is an
+// error, since the records after it are intact.
+func replayLog(r io.Reader, st *storeState) (int64, error) {
+	br := bufio.NewReader(r)
+	var off int64
+	hdr := make([]byte, frameHeaderSize)
+	for {
+		if _, err := io.ReadFull(br, hdr); err != nil {
+			return off, nil
+		}
+		n := binary.BigEndian.Uint32(hdr[0:4])
+		sum := binary.BigEndian.Uint32(hdr[4:8])
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return off, nil
+		}
+		if crc32.ChecksumIEEE(payload) != sum {
+			return off, nil
+		}
+		var rec record
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&rec); err != nil {
+			return off, fmt.Errorf("cannot decode the log record at offset %d: %v", off, err)
+		}
+		if err := st.apply(&rec); err != nil {
+			return off, err
+		}
+		off += frameHeaderSize + int64(n)
+	}
+}
+
+func encodeFrame(rec *record) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, frameHeaderSize))
+	if err := gob.NewEncoder(&buf).Encode(rec); err != nil {
+		return nil, err
+	}
+	b := buf.Bytes()
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(b)-frameHeaderSize))
+	binary.BigEndian.PutUint32(b[4:8], crc32.ChecksumIEEE(b[frameHeaderSize:]))
+	return b, nil
+}
+
+// append writes the record to the log. It is safe to call on a nil log.
+func (l *appendLog) append(rec record) error {
+	if l == nil {
+		return nil
+	}
+	b, err := encodeFrame(&rec)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.f.Write(b); err != nil {
+		// drop any partially written frame so later records stay readable
+		l.f.Truncate(l.size)
+		l.f.Seek(l.size, io.SeekStart)
+		return err
+	}
+	l.size += int64(len(b))
+	if l.capturing {
+		l.rewriteBuf = append(l.rewriteBuf, b)
+	}
+	if l.policy == SyncAlways {
+		if err := l.f.Sync(); err != nil {
+			return err
+		}
+	} else {
+		l.dirty = true
+	}
+
+	// compact in the background once the log has doubled since the last rewrite
+	if l.compactSize > 0 && !l.rewriting && l.size > l.compactSize && l.size > 2*l.lastRewrite {
+		l.rewriting = true
+		go func() {
+			if err := l.doRewrite(); err != nil {
+				l.logger.Printf("ERROR: log rewrite failed: %v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// rewrite replaces the log with the minimal set of records needed to
+// recreate the current contents of the store. Records appended after the
+// contents are dumped are carried over to the new log. If a rewrite is
+// already running, rewrite waits for it to finish and starts a new one.
+func (l *appendLog) rewrite() error {
+	l.mu.Lock()
+	for l.rewriting {
+		l.rewritten.Wait()
+	}
+	l.rewriting = true
+	l.mu.Unlock()
+
+	return l.doRewrite()
+}
+
+// startCapture keeps the frames appended from now on for the rewrite. The
+// dump calls it while the event loops are paused, so the kept frames are
+// exactly the ones missing from the dump.
+func (l *appendLog) startCapture() {
+	l.mu.Lock()
+	l.capturing = true
+	l.rewriteBuf = nil
+	l.mu.Unlock()
+}
+
+func (l *appendLog) doRewrite() error {
+	defer func() {
+		l.mu.Lock()
+		l.rewriting = false
+		l.capturing = false
+		l.rewriteBuf = nil
+		l.rewritten.Broadcast()
+		l.mu.Unlock()
+	}()
+
+	recs, err := l.dump(l.startCapture)
+	if err != nil {
+		return err
+	}
+
+	tmp := l.path + ".rewrite"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	w := bufio.NewWriter(f)
+	var size int64
+	for i := range recs {
+		b, err := encodeFrame(&recs[i])
+		if err != nil {
+			return fail(err)
+		}
+		if _, err := w.Write(b); err != nil {
+			return fail(err)
+		}
+		size += int64(len(b))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return fail(fmt.Errorf("log closed"))
+	}
+	for _, b := range l.rewriteBuf {
+		if _, err := w.Write(b); err != nil {
+			return fail(err)
+		}
+		size += int64(len(b))
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return fail(err)
+	}
+	l.f.Close()
+	l.f = f
+	l.size = size
+	l.lastRewrite = size
+	l.dirty = false
+	return nil
+}
+
+func (l *appendLog) syncLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.mu.Lock()
+			if l.dirty {
+				if err := l.f.Sync(); err != nil {
+					l.logger.Printf("ERROR: log sync failed: %v", err)
+				}
+				l.dirty = false
+			}
+			l.mu.Unlock()
+
+		case <-l.close:
+			return
+		}
+	}
+}
+
+// closeLog flushes and closes the log. It is safe to call on a nil log.
+func (l *appendLog) closeLog() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.close)
+	if err := l.f.Sync(); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
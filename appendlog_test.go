@@ -0,0 +1,282 @@
+package gostore_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tonjun/gostore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AppendLog", func() {
+
+	var dir string
+	var path string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "gostore")
+		Expect(err).To(BeNil())
+		path = filepath.Join(dir, "store.log")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	open := func(policy gostore.SyncPolicy) gostore.Store {
+		store := gostore.NewStoreWithConfig(gostore.Config{LogPath: path, LogSync: policy})
+		store.Init()
+		return store
+	}
+
+	It("Init() should replay the mutations recorded in the log", func() {
+		store := open(gostore.SyncAlways)
+		Expect(store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)).To(BeNil())
+		Expect(store.Put(&gostore.Item{Key: "k2", ID: "2", Value: "v2"}, 0)).To(BeNil())
+		Expect(store.Put(&gostore.Item{Key: "k1", ID: "3", Value: "v3"}, 0)).To(BeNil())
+		Expect(store.Del("k2")).To(BeNil())
+		Expect(store.ListPush("l1", &gostore.Item{ID: "a", Value: "a data"})).To(BeNil())
+		Expect(store.ListPush("l1", &gostore.Item{ID: "b", Value: "b data"})).To(BeNil())
+		Expect(store.ListDel("l1", &gostore.Item{ID: "a"})).To(BeNil())
+		store.Close()
+
+		store = open(gostore.SyncAlways)
+		defer store.Close()
+
+		i, found, err := store.Get("k1")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(i.ID).To(Equal("3"))
+		Expect(i.Value).To(Equal("v3"))
+
+		_, found, err = store.Get("k2")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeFalse())
+
+		items, found, err := store.ListGet("l1")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(len(items)).To(Equal(1))
+		if len(items) == 1 {
+			Expect(items[0].ID).To(Equal("b"))
+		}
+	})
+
+	It("Replay should skip items that expired while the store was closed", func() {
+		store := open(gostore.SyncNever)
+		Expect(store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 100*time.Millisecond)).To(BeNil())
+		Expect(store.Put(&gostore.Item{Key: "k2", ID: "2", Value: "v2"}, 20*time.Second)).To(BeNil())
+		store.Close()
+
+		time.Sleep(200 * time.Millisecond)
+
+		store = open(gostore.SyncNever)
+		defer store.Close()
+
+		_, found, _ := store.Get("k1")
+		Expect(found).To(BeFalse())
+		_, found, _ = store.Get("k2")
+		Expect(found).To(BeTrue())
+	})
+
+	It("Replay should skip items that went idle while the store was closed", func() {
+		store := open(gostore.SyncNever)
+		opts := gostore.PutOptions{IdleTimeout: 100 * time.Millisecond}
+		Expect(store.PutWithOptions(&gostore.Item{Key: "session", ID: "1", Value: "data"}, opts)).To(BeNil())
+		store.Close()
+
+		time.Sleep(200 * time.Millisecond)
+
+		store = open(gostore.SyncNever)
+		defer store.Close()
+
+		_, found, _ := store.Get("session")
+		Expect(found).To(BeFalse())
+	})
+
+	It("Reads of items with an IdleTimeout should not be logged", func() {
+		store := open(gostore.SyncAlways)
+		defer store.Close()
+
+		opts := gostore.PutOptions{IdleTimeout: time.Minute}
+		Expect(store.PutWithOptions(&gostore.Item{Key: "session", ID: "1", Value: "data"}, opts)).To(BeNil())
+		fi, err := os.Stat(path)
+		Expect(err).To(BeNil())
+
+		for i := 0; i < 10; i++ {
+			_, found, _ := store.Get("session")
+			Expect(found).To(BeTrue())
+		}
+		after, err := os.Stat(path)
+		Expect(err).To(BeNil())
+		Expect(after.Size()).To(Equal(fi.Size()))
+	})
+
+	It("Replay should discard an incomplete record at the end of the log", func() {
+		store := open(gostore.SyncEverySecond)
+		Expect(store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)).To(BeNil())
+		store.Close()
+
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		Expect(err).To(BeNil())
+		f.Write([]byte{0, 0, 1, 0, 1, 2, 3})
+		f.Close()
+
+		store = open(gostore.SyncEverySecond)
+		Expect(store.Put(&gostore.Item{Key: "k2", ID: "2", Value: "v2"}, 0)).To(BeNil())
+		store.Close()
+
+		store = open(gostore.SyncEverySecond)
+		defer store.Close()
+
+		_, found, _ := store.Get("k1")
+		Expect(found).To(BeTrue())
+		_, found, _ = store.Get("k2")
+		Expect(found).To(BeTrue())
+	})
+
+	It("Init() should fail instead of truncating a record it cannot decode", func() {
+		store := open(gostore.SyncAlways)
+		Expect(store.Put(&gostore.Item{Key: "a", ID: "1", Value: "a"}, 0)).To(BeNil())
+		store.Close()
+		head, err := ioutil.ReadFile(path)
+		Expect(err).To(BeNil())
+		Expect(os.Remove(path)).To(BeNil())
+
+		store = open(gostore.SyncAlways)
+		Expect(store.Put(&gostore.Item{Key: "b", ID: "2", Value: "b"}, 0)).To(BeNil())
+		store.Close()
+		tail, err := ioutil.ReadFile(path)
+		Expect(err).To(BeNil())
+
+		// a frame with a valid checksum and a payload gob cannot decode
+		payload := []byte("not a gob record")
+		frame := make([]byte, 8, 8+len(payload))
+		binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+		binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+		frame = append(frame, payload...)
+
+		contents := append(append(head, frame...), tail...)
+		Expect(ioutil.WriteFile(path, contents, 0644)).To(BeNil())
+
+		_, err = gostore.New(gostore.WithPersistence(path, gostore.SyncAlways))
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("cannot decode"))
+		after, err := ioutil.ReadFile(path)
+		Expect(err).To(BeNil())
+		Expect(after).To(Equal(contents))
+	})
+
+	It("Restore() should be kept by the log even if the log is not rewritten", func() {
+		source := gostore.NewStore()
+		source.Init()
+		Expect(source.Put(&gostore.Item{Key: "new", ID: "1", Value: "restored"}, 0)).To(BeNil())
+		var buf bytes.Buffer
+		Expect(source.Snapshot(&buf)).To(BeNil())
+		source.Close()
+
+		store := open(gostore.SyncAlways)
+		Expect(store.Put(&gostore.Item{Key: "old", ID: "1", Value: "replaced"}, 0)).To(BeNil())
+
+		// a directory in the way of the rewritten log makes the rewrite fail
+		Expect(os.Mkdir(path+".rewrite", 0755)).To(BeNil())
+		Expect(store.Restore(&buf)).To(BeNil())
+		Expect(store.Put(&gostore.Item{Key: "after", ID: "1", Value: "v"}, 0)).To(BeNil())
+		store.Close()
+
+		store = open(gostore.SyncAlways)
+		defer store.Close()
+
+		_, found, _ := store.Get("old")
+		Expect(found).To(BeFalse())
+		i, found, _ := store.Get("new")
+		Expect(found).To(BeTrue())
+		Expect(i.Value).To(Equal("restored"))
+		_, found, _ = store.Get("after")
+		Expect(found).To(BeTrue())
+	})
+
+	It("CompactLog() should shrink the log and keep the contents", func() {
+		store := open(gostore.SyncNever)
+		for i := 0; i < 50; i++ {
+			Expect(store.Put(&gostore.Item{Key: "k1", ID: "1", Value: i}, 0)).To(BeNil())
+		}
+		Expect(store.ListPush("l1", &gostore.Item{ID: "a", Value: "a data"})).To(BeNil())
+		before, err := os.Stat(path)
+		Expect(err).To(BeNil())
+
+		Expect(store.CompactLog()).To(BeNil())
+		after, err := os.Stat(path)
+		Expect(err).To(BeNil())
+		Expect(after.Size()).To(BeNumerically("<", before.Size()))
+
+		Expect(store.Put(&gostore.Item{Key: "k2", ID: "2", Value: "v2"}, 0)).To(BeNil())
+		store.Close()
+
+		store = open(gostore.SyncNever)
+		defer store.Close()
+
+		i, found, _ := store.Get("k1")
+		Expect(found).To(BeTrue())
+		Expect(i.Value).To(Equal(49))
+		_, found, _ = store.Get("k2")
+		Expect(found).To(BeTrue())
+		items, _, _ := store.ListGet("l1")
+		Expect(len(items)).To(Equal(1))
+	})
+
+	It("The log should be compacted in the background once it exceeds LogCompactSize", func() {
+		store := gostore.NewStoreWithConfig(gostore.Config{LogPath: path, LogSync: gostore.SyncNever, LogCompactSize: 4096})
+		store.Init()
+		defer store.Close()
+
+		var written int64
+		for i := 0; i < 200; i++ {
+			Expect(store.Put(&gostore.Item{Key: "k1", ID: "1", Value: i}, 0)).To(BeNil())
+			fi, err := os.Stat(path)
+			Expect(err).To(BeNil())
+			if fi.Size() > written {
+				written = fi.Size()
+			}
+		}
+		Expect(written).To(BeNumerically(">", 4096))
+		Eventually(func() int64 {
+			fi, _ := os.Stat(path)
+			return fi.Size()
+		}).Should(BeNumerically("<", 4096))
+	})
+
+	It("Init() should report why it failed to the later calls", func() {
+		store := gostore.NewStoreWithConfig(gostore.Config{LogPath: filepath.Join(dir, "missing", "store.log")})
+		store.Init()
+		defer store.Close()
+
+		err := store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("Init failed"))
+		Expect(err.Error()).To(ContainSubstring("no such file or directory"))
+	})
+
+	It("Close() should be safe to call twice", func() {
+		store := open(gostore.SyncAlways)
+		Expect(store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)).To(BeNil())
+		store.Close()
+		Expect(store.Close).NotTo(Panic())
+	})
+
+	It("CompactLog() should fail when the log is not enabled", func() {
+		store := gostore.NewStore()
+		store.Init()
+		defer store.Close()
+		Expect(store.CompactLog()).ToNot(BeNil())
+	})
+
+})
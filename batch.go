@@ -0,0 +1,246 @@
+package gostore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type batchOpKind int
+
+const (
+	batchPut batchOpKind = iota
+	batchDel
+	batchListPush
+	batchListDel
+)
+
+type batchOp struct {
+	kind batchOpKind
+	key  string
+	item *Item
+	opts PutOptions
+	d    time.Duration
+}
+
+// Batch groups Put, Del, ListPush and ListDel operations that are applied
+// atomically by Store.Apply. The zero value is an empty batch.
+type Batch struct {
+	ops []batchOp
+}
+
+// NewBatch returns an empty batch
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+// Put adds saving the item with an optional expiry duration to the batch
+func (b *Batch) Put(item *Item, d time.Duration) *Batch {
+	return b.PutWithOptions(item, PutOptions{TTL: d})
+}
+
+// PutWithOptions adds saving the item with the given expiry options to the batch
+func (b *Batch) PutWithOptions(item *Item, opts PutOptions) *Batch {
+	b.ops = append(b.ops, batchOp{kind: batchPut, item: copyItem(item), opts: opts})
+	return b
+}
+
+// Del adds deleting the item for the key to the batch
+func (b *Batch) Del(key string) *Batch {
+	b.ops = append(b.ops, batchOp{kind: batchDel, key: key})
+	return b
+}
+
+// ListPush adds pushing the item to the list to the batch
+func (b *Batch) ListPush(key string, value *Item) *Batch {
+	return b.ListPushWithTTL(key, value, 0)
+}
+
+// ListPushWithTTL adds pushing the item to the list to the batch. The item is
+// removed from the list once the duration d elapses.
+func (b *Batch) ListPushWithTTL(key string, value *Item, d time.Duration) *Batch {
+	b.ops = append(b.ops, batchOp{kind: batchListPush, key: key, item: copyItem(value), d: d})
+	return b
+}
+
+// ListDel adds deleting the item from the list to the batch
+func (b *Batch) ListDel(key string, value *Item) *Batch {
+	b.ops = append(b.ops, batchOp{kind: batchListDel, key: key, item: copyItem(value)})
+	return b
+}
+
+// Len returns the number of operations in the batch
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// validate checks every operation before any of them is applied
+func (b *Batch) validate() error {
+	for n, op := range b.ops {
+		var err error
+		switch op.kind {
+		case batchPut:
+			if op.item == nil {
+				err = fmt.Errorf("nil item")
+			} else if len(op.item.Key) == 0 || len(op.item.ID) == 0 {
+				err = fmt.Errorf("invalid item")
+			}
+		case batchDel:
+			if len(op.key) == 0 {
+				err = fmt.Errorf("Invalid key")
+			}
+		case batchListPush, batchListDel:
+			if op.item == nil {
+				err = fmt.Errorf("nil value")
+			} else if len(op.key) == 0 || len(op.item.ID) == 0 {
+				err = fmt.Errorf("invalid input")
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("batch operation %d: %v", n, err)
+		}
+	}
+	return nil
+}
+
+// pauseAll pauses both event loops until the returned function is called.
+// Events published meanwhile are queued without waiting for OverflowBlock
+// subscribers, whose callbacks may need the loops to make room.
+func (s *store) pauseAll(ctx context.Context) (release func(), err error) {
+	// the loops are always paused in the same order so concurrent batches
+	// cannot deadlock
+	releaseKV, err := s.kv.pause(ctx)
+	if err != nil {
+		return nil, err
+	}
+	releaseLS, err := s.ls.pause(ctx)
+	if err != nil {
+		releaseKV()
+		return nil, err
+	}
+	s.events.holdWaits()
+	return func() {
+		s.events.releaseWaits()
+		releaseLS()
+		releaseKV()
+	}, nil
+}
+
+// applyBatch applies the operations while both event loops are paused. The
+// operations are written to the log as a single record, so a crash either
+// keeps all of them or none, and are reported as a single EventBatch. If
+// check is not nil, nothing is applied unless it returns nil once the loops
+// are paused.
+func (s *store) applyBatch(ctx context.Context, b *Batch, check func() error) error {
+	if err := b.validate(); err != nil {
+		return err
+	}
+	if len(b.ops) == 0 && check == nil {
+		return nil
+	}
+
+	release, err := s.pauseAll(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	now := s.opts.now()
+	if check != nil {
+		s.kv.expireItems(now)
+		s.ls.expireItems(now)
+		if err := check(); err != nil {
+			return err
+		}
+		if len(b.ops) == 0 {
+			return nil
+		}
+	}
+	items := make([]Item, len(b.ops))
+	recs := make([]record, len(b.ops))
+	for n, op := range b.ops {
+		switch op.kind {
+		case batchPut:
+			items[n] = newPutItem(op.item, op.opts, now)
+			recs[n] = itemRecord(opPut, items[n].Key, &items[n])
+		case batchDel:
+			recs[n] = record{Op: opDel, Key: op.key}
+		case batchListPush:
+			if err := s.ls.checkKind(op.key, kindIDList); err != nil {
+				return err
+			}
+			items[n] = newListItem(op.item, op.d, now)
+			recs[n] = itemRecord(opListPush, op.key, &items[n])
+		case batchListDel:
+			if err := s.ls.checkKind(op.key, kindIDList); err != nil {
+				return err
+			}
+			recs[n] = record{Op: opListDel, Key: op.key, ID: op.item.ID}
+		}
+	}
+	if err := s.kv.batchFits(b.ops, items); err != nil {
+		return err
+	}
+	if err := s.wlog.append(record{Op: opBatch, Batch: recs}); err != nil {
+		return err
+	}
+
+	var changes []Event
+	changed := make(map[string]bool)
+	var lists []string
+	for n, op := range b.ops {
+		switch op.kind {
+		case batchPut:
+			i := items[n]
+			if old, ok := s.kv.kval[i.Key]; ok {
+				changes = append(changes, Event{Kind: EventOverwrite, Key: i.Key, Old: &old, New: &i})
+			} else {
+				changes = append(changes, Event{Kind: EventPut, Key: i.Key, New: &i})
+			}
+			s.kv.modified(&i)
+			s.kv.setItem(i)
+
+		case batchDel:
+			if old, ok := s.kv.kval[op.key]; ok {
+				s.kv.deleteItem(op.key)
+				changes = append(changes, Event{Kind: EventDel, Key: op.key, Old: &old})
+			}
+
+		case batchListPush:
+			i := items[n]
+			old := s.ls.pushItem(op.key, i)
+			changes = append(changes, Event{Kind: EventListPush, Key: op.key, Old: old, New: &i})
+			if old != nil {
+				s.ls.triggerListDiff(op.key, ListOpPush, []Item{i}, []Item{*old})
+			} else {
+				s.ls.triggerListDiff(op.key, ListOpPush, []Item{i}, nil)
+				if !changed[op.key] {
+					changed[op.key] = true
+					lists = append(lists, op.key)
+				}
+			}
+
+		case batchListDel:
+			if _, ok := s.ls.ktree[op.key]; !ok {
+				continue
+			}
+			if old, ok := s.ls.removeItem(op.key, op.item.ID); ok {
+				changes = append(changes, Event{Kind: EventListDel, Key: op.key, Old: &old})
+				s.ls.triggerListDiff(op.key, ListOpDel, nil, []Item{old})
+				if !changed[op.key] {
+					changed[op.key] = true
+					lists = append(lists, op.key)
+				}
+			}
+		}
+	}
+	s.events.publishBatch(changes)
+	s.kv.evictOverflow()
+	for _, key := range lists {
+		s.ls.triggerListDidChange(key)
+	}
+	for _, key := range lists {
+		s.ls.serveWaiters(key)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package gostore_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/tonjun/gostore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batch", func() {
+
+	var store gostore.Store
+
+	BeforeEach(func() {
+		store = gostore.NewStore()
+		store.Init()
+	})
+
+	AfterEach(func() {
+		store.Close()
+	})
+
+	It("Apply() should not wait for callbacks that read the store", func() {
+		blocking := gostore.NewStoreWithConfig(gostore.Config{
+			Delivery: gostore.DeliveryOptions{QueueSize: 1, Overflow: gostore.OverflowBlock},
+		})
+		blocking.Init()
+		defer blocking.Close()
+
+		lists := make(chan int, 10)
+		blocking.OnListDidChange(func(key string, items []*gostore.Item) {
+			n, _ := blocking.ListLen(key)
+			lists <- n
+		})
+
+		b := gostore.NewBatch()
+		for _, key := range []string{"l1", "l2", "l3", "l4", "l5"} {
+			b.ListPush(key, &gostore.Item{ID: "a", Value: "a data"})
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- blocking.Apply(b)
+		}()
+		Eventually(done, "1s").Should(Receive(BeNil()))
+		for i := 0; i < 5; i++ {
+			Eventually(lists).Should(Receive(Equal(1)))
+		}
+		Expect(blocking.DroppedEvents()).To(BeZero())
+	})
+
+	It("Apply() should apply every operation of the batch", func() {
+		store.Put(&gostore.Item{Key: "k2", ID: "2", Value: "v2"}, 0)
+		store.ListPush("l1", &gostore.Item{ID: "b", Value: "b data"})
+
+		b := gostore.NewBatch().
+			Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0).
+			Del("k2").
+			ListPush("l1", &gostore.Item{ID: "a", Value: "a data"}).
+			ListDel("l1", &gostore.Item{ID: "b"})
+		Expect(b.Len()).To(Equal(4))
+		Expect(store.Apply(b)).To(BeNil())
+
+		item, found, err := store.Get("k1")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(item.Value).To(Equal("v1"))
+
+		_, found, _ = store.Get("k2")
+		Expect(found).To(BeFalse())
+
+		items, _, _ := store.ListGet("l1")
+		Expect(items).To(HaveLen(1))
+		Expect(items[0].ID).To(Equal("a"))
+	})
+
+	It("Apply() should not apply anything if an operation is invalid", func() {
+		b := gostore.NewBatch().
+			Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0).
+			ListPush("l1", &gostore.Item{Value: "no id"})
+		Expect(store.Apply(b)).NotTo(BeNil())
+
+		_, found, _ := store.Get("k1")
+		Expect(found).To(BeFalse())
+		_, found, _ = store.ListGet("l1")
+		Expect(found).To(BeFalse())
+	})
+
+	It("Watch() should receive a single event for the batch", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		all := store.Watch(ctx, gostore.EventFilter{})
+		puts := store.Watch(ctx, gostore.EventFilter{Kinds: gostore.EventPut})
+
+		b := gostore.NewBatch().
+			Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0).
+			ListPush("l1", &gostore.Item{ID: "a", Value: "a data"})
+		Expect(store.Apply(b)).To(BeNil())
+
+		var e gostore.Event
+		Eventually(all).Should(Receive(&e))
+		Expect(e.Kind).To(Equal(gostore.EventBatch))
+		Expect(e.Changes).To(HaveLen(2))
+		Expect(e.Changes[0].Kind).To(Equal(gostore.EventPut))
+		Expect(e.Changes[0].New.Value).To(Equal("v1"))
+		Expect(e.Changes[1].Kind).To(Equal(gostore.EventListPush))
+		Expect(e.Changes[1].Key).To(Equal("l1"))
+		Consistently(all).ShouldNot(Receive())
+
+		Eventually(puts).Should(Receive(&e))
+		Expect(e.Kind).To(Equal(gostore.EventPut))
+		Expect(e.Key).To(Equal("k1"))
+		Consistently(puts).ShouldNot(Receive())
+	})
+
+	It("OnListDidChange() should be called once per changed list", func() {
+		lengths := make(chan int, 10)
+		store.OnListDidChange(func(key string, items []*gostore.Item) {
+			lengths <- len(items)
+		})
+
+		b := gostore.NewBatch().
+			ListPush("l1", &gostore.Item{ID: "a", Value: "a data"}).
+			ListPush("l1", &gostore.Item{ID: "b", Value: "b data"})
+		Expect(store.Apply(b)).To(BeNil())
+
+		Eventually(lengths).Should(Receive(Equal(2)))
+		Consistently(lengths).ShouldNot(Receive())
+	})
+
+	It("A batch should be replayed from the append-only log", func() {
+		dir, err := ioutil.TempDir("", "gostore")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		cfg := gostore.Config{LogPath: filepath.Join(dir, "store.log"), LogSync: gostore.SyncAlways}
+
+		logged := gostore.NewStoreWithConfig(cfg)
+		logged.Init()
+		b := gostore.NewBatch().
+			Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0).
+			ListPush("l1", &gostore.Item{ID: "a", Value: "a data"})
+		Expect(logged.Apply(b)).To(BeNil())
+		logged.Close()
+
+		logged = gostore.NewStoreWithConfig(cfg)
+		logged.Init()
+		defer logged.Close()
+		_, found, _ := logged.Get("k1")
+		Expect(found).To(BeTrue())
+		items, _, _ := logged.ListGet("l1")
+		Expect(items).To(HaveLen(1))
+	})
+
+})
@@ -0,0 +1,154 @@
+package gostore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// popFirst removes and returns the first member of the list for the key. The
+// first member of a list ordered by ID is the one with the lowest ID.
+func (s *listStore) popFirst(key string) (Item, bool, error) {
+	if _, ok := s.seqs[key]; ok {
+		r := s.applySeq(seqReq{op: seqLPop, key: key})
+		if r.err != nil || !r.found {
+			return Item{}, false, r.err
+		}
+		return r.items[0], true, nil
+	}
+	tree, ok := s.ktree[key]
+	if !ok || tree.Len() == 0 {
+		return Item{}, false, nil
+	}
+	id := tree.Min().(treeItem).Value.ID
+	if err := s.wlog.append(record{Op: opListDel, Key: key, ID: id}); err != nil {
+		return Item{}, false, err
+	}
+	old, _ := s.removeItem(key, id)
+	s.events.publish(EventListDel, key, &old, nil)
+	s.triggerListDiff(key, ListOpDel, nil, []Item{old})
+	s.triggerListDidChange(key)
+	return old, true, nil
+}
+
+// waitPop pops the first member of the first of the lists that is not empty,
+// or queues the request until a member is pushed to one of them
+func (s *listStore) waitPop(r *popReq) {
+	for _, key := range r.keys {
+		item, ok, err := s.popFirst(key)
+		if err != nil || ok {
+			r.resp <- popResp{key: key, item: item, err: err}
+			return
+		}
+	}
+	for _, key := range r.keys {
+		s.waiters[key] = append(s.waiters[key], r)
+	}
+}
+
+// serveWaiters hands the members of the list for the key to the requests
+// waiting on it, in the order they started waiting
+func (s *listStore) serveWaiters(key string) {
+	if len(s.waiters[key]) == 0 {
+		return
+	}
+	// members that are due must not be handed out
+	s.expireItems(s.opts.now())
+	for len(s.waiters[key]) > 0 {
+		r := s.waiters[key][0]
+		item, ok, err := s.popFirst(key)
+		if err == nil && !ok {
+			return
+		}
+		s.removeWaiter(r)
+		r.resp <- popResp{key: key, item: item, err: err}
+	}
+}
+
+// removeWaiter removes the request from the queues of all its keys
+func (s *listStore) removeWaiter(r *popReq) {
+	for _, key := range r.keys {
+		queue := s.waiters[key]
+		for n, w := range queue {
+			if w == r {
+				queue = append(queue[:n], queue[n+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(s.waiters, key)
+		} else {
+			s.waiters[key] = queue
+		}
+	}
+}
+
+// closeWaiters fails every waiting request
+func (s *listStore) closeWaiters() {
+	for len(s.waiters) > 0 {
+		for _, queue := range s.waiters {
+			r := queue[0]
+			s.removeWaiter(r)
+			r.resp <- popResp{err: fmt.Errorf("store closed")}
+			break
+		}
+	}
+}
+
+func (s *listStore) blockingPop(ctx context.Context, keys []string) (string, *Item, error) {
+	if len(keys) == 0 {
+		return "", nil, fmt.Errorf("invalid input")
+	}
+	for _, key := range keys {
+		if len(key) == 0 {
+			return "", nil, fmt.Errorf("invalid input")
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return "", nil, err
+	}
+	req := &popReq{
+		keys: keys,
+		resp: make(chan popResp, 1),
+	}
+	select {
+	case s.lpop <- req:
+	case <-ctx.Done():
+		return "", nil, ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
+		return "", nil, fmt.Errorf("Pop channel timeout")
+	}
+
+	select {
+	case r := <-req.resp:
+		if r.err != nil {
+			return "", nil, r.err
+		}
+		return r.key, &r.item, nil
+	case <-ctx.Done():
+	}
+
+	// the request must leave the queues before returning, or a member pushed
+	// later would be handed to nobody. The loop always serves the
+	// cancellation unless it has returned, in which case it has already
+	// answered the request.
+	cancel := cancelPopReq{
+		req:  req,
+		resp: make(chan bool),
+	}
+	select {
+	case s.lcancel <- cancel:
+		<-cancel.resp
+	case <-s.done:
+	}
+
+	// the member may have been handed over before the request was cancelled
+	select {
+	case r := <-req.resp:
+		if r.err == nil {
+			return r.key, &r.item, nil
+		}
+	default:
+	}
+	return "", nil, ctx.Err()
+}
@@ -0,0 +1,119 @@
+package gostore_test
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tonjun/gostore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compare and swap", func() {
+
+	var store gostore.Store
+
+	BeforeEach(func() {
+		store = gostore.NewStore()
+		store.Init()
+	})
+
+	AfterEach(func() {
+		store.Close()
+	})
+
+	It("PutIfAbsent() should only save the item if the key does not exist", func() {
+		Expect(store.PutIfAbsent(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)).To(BeNil())
+
+		err := store.PutIfAbsent(&gostore.Item{Key: "k1", ID: "2", Value: "v2"}, 0)
+		Expect(gostore.IsConflict(err)).To(BeTrue())
+		Expect(err.(*gostore.ConflictError).Actual).To(Equal("1"))
+
+		item, _, _ := store.Get("k1")
+		Expect(item.Value).To(Equal("v1"))
+	})
+
+	It("PutIfAbsent() should treat an expired item as absent", func() {
+		store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 10*time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		Expect(store.PutIfAbsent(&gostore.Item{Key: "k1", ID: "2", Value: "v2"}, 0)).To(BeNil())
+	})
+
+	It("PutIfMatch() should only save the item if the current ID matches", func() {
+		err := store.PutIfMatch(&gostore.Item{Key: "k1", ID: "2", Value: "v2"}, "1", 0)
+		Expect(gostore.IsConflict(err)).To(BeTrue())
+		Expect(err.(*gostore.ConflictError).Actual).To(Equal(""))
+
+		store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)
+		Expect(store.PutIfMatch(&gostore.Item{Key: "k1", ID: "2", Value: "v2"}, "1", 0)).To(BeNil())
+
+		err = store.PutIfMatch(&gostore.Item{Key: "k1", ID: "3", Value: "v3"}, "1", 0)
+		Expect(gostore.IsConflict(err)).To(BeTrue())
+		Expect(err.(*gostore.ConflictError).Expected).To(Equal("1"))
+		Expect(err.(*gostore.ConflictError).Actual).To(Equal("2"))
+
+		item, _, _ := store.Get("k1")
+		Expect(item.Value).To(Equal("v2"))
+	})
+
+	It("DelIfMatch() should only delete the item if the current ID matches", func() {
+		store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)
+
+		Expect(gostore.IsConflict(store.DelIfMatch("k1", "2"))).To(BeTrue())
+		_, found, _ := store.Get("k1")
+		Expect(found).To(BeTrue())
+
+		Expect(store.DelIfMatch("k1", "1")).To(BeNil())
+		_, found, _ = store.Get("k1")
+		Expect(found).To(BeFalse())
+
+		Expect(gostore.IsConflict(store.DelIfMatch("k1", "1"))).To(BeTrue())
+	})
+
+	It("PutIfMatch() and DelIfMatch() should not match hashes, sets or counters", func() {
+		store.HSet("h", "f", "v")
+		store.SAdd("s", "a")
+		store.Incr("c")
+
+		for _, key := range []string{"h", "s", "c"} {
+			err := store.PutIfMatch(&gostore.Item{Key: key, ID: "1", Value: "v"}, "", 0)
+			Expect(gostore.IsConflict(err)).To(BeTrue())
+			err = store.DelIfMatch(key, "")
+			Expect(gostore.IsConflict(err)).To(BeTrue())
+		}
+		n, _ := store.HLen("h")
+		Expect(n).To(Equal(1))
+		n, _ = store.SCard("s")
+		Expect(n).To(Equal(1))
+		c, _ := store.IncrBy("c", 0)
+		Expect(c).To(Equal(int64(1)))
+	})
+
+	It("Only one concurrent PutIfMatch() should succeed for a version", func() {
+		store.Put(&gostore.Item{Key: "k1", ID: "0", Value: 0}, 0)
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		succeeded := 0
+		for i := 1; i <= 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				err := store.PutIfMatch(&gostore.Item{Key: "k1", ID: fmt.Sprint(i), Value: i}, "0", 0)
+				if err == nil {
+					mu.Lock()
+					succeeded++
+					mu.Unlock()
+				} else {
+					Expect(gostore.IsConflict(err)).To(BeTrue())
+				}
+			}(i)
+		}
+		wg.Wait()
+		Expect(succeeded).To(Equal(1))
+	})
+
+})
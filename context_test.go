@@ -0,0 +1,231 @@
+package gostore_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/tonjun/gostore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// stall blocks the event loop sizing it until release is closed
+type stall struct {
+	release chan struct{}
+}
+
+func (s stall) Size() int64 {
+	<-s.release
+	return 1
+}
+
+var _ = Describe("Context", func() {
+
+	var store gostore.Store
+
+	BeforeEach(func() {
+		store = gostore.NewStore()
+		store.Init()
+	})
+
+	AfterEach(func() {
+		store.Close()
+	})
+
+	It("PutCtx(), GetCtx() and DelCtx() should work like Put, Get and Del", func() {
+		ctx := context.Background()
+		Expect(store.PutCtx(ctx, &gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)).To(BeNil())
+		item, found, err := store.GetCtx(ctx, "k1")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(item.Value).To(Equal("v1"))
+
+		Expect(store.DelCtx(ctx, "k1")).To(BeNil())
+		_, found, _ = store.GetCtx(ctx, "k1")
+		Expect(found).To(BeFalse())
+	})
+
+	It("ListPushCtx(), ListGetCtx() and ListDelCtx() should work like ListPush, ListGet and ListDel", func() {
+		ctx := context.Background()
+		Expect(store.ListPushCtx(ctx, "l1", &gostore.Item{ID: "a", Value: "a data"})).To(BeNil())
+		items, found, err := store.ListGetCtx(ctx, "l1")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(items).To(HaveLen(1))
+
+		Expect(store.ListDelCtx(ctx, "l1", &gostore.Item{ID: "a"})).To(BeNil())
+		items, _, _ = store.ListGetCtx(ctx, "l1")
+		Expect(items).To(BeEmpty())
+	})
+
+	It("the conditional and TTL variants should have Ctx forms", func() {
+		ctx := context.Background()
+		Expect(store.PutIfAbsentCtx(ctx, &gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)).To(BeNil())
+		Expect(gostore.IsConflict(store.PutIfAbsentCtx(ctx, &gostore.Item{Key: "k1", ID: "2", Value: "v2"}, 0))).To(BeTrue())
+		Expect(store.PutIfMatchCtx(ctx, &gostore.Item{Key: "k1", ID: "2", Value: "v2"}, "1", 0)).To(BeNil())
+		Expect(gostore.IsConflict(store.DelIfMatchCtx(ctx, "k1", "1"))).To(BeTrue())
+		Expect(store.DelIfMatchCtx(ctx, "k1", "2")).To(BeNil())
+		_, found, _ := store.Get("k1")
+		Expect(found).To(BeFalse())
+
+		Expect(store.ListPushWithTTLCtx(ctx, "l1", &gostore.Item{ID: "a", Value: "a data"}, 10*time.Millisecond)).To(BeNil())
+		n, _ := store.ListLen("l1")
+		Expect(n).To(Equal(1))
+		time.Sleep(20 * time.Millisecond)
+		n, _ = store.ListLen("l1")
+		Expect(n).To(Equal(0))
+	})
+
+	It("a cancelled context should fail the call without applying it", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		Expect(store.PutCtx(ctx, &gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)).To(Equal(context.Canceled))
+		Expect(store.ListPushCtx(ctx, "l1", &gostore.Item{ID: "a"})).To(Equal(context.Canceled))
+		Expect(store.ListPushWithTTLCtx(ctx, "l1", &gostore.Item{ID: "a"}, time.Minute)).To(Equal(context.Canceled))
+		Expect(store.PutIfAbsentCtx(ctx, &gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)).To(Equal(context.Canceled))
+		_, _, err := store.GetCtx(ctx, "k1")
+		Expect(err).To(Equal(context.Canceled))
+
+		_, found, _ := store.Get("k1")
+		Expect(found).To(BeFalse())
+		n, _ := store.ListLen("l1")
+		Expect(n).To(Equal(0))
+	})
+
+	It("the deadline should be honoured while waiting for a busy store", func() {
+		release := make(chan struct{})
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		// the reply to this put is abandoned while the store is stalled
+		err := store.PutCtx(ctx, &gostore.Item{Key: "slow", ID: "1", Value: stall{release: release}}, 0)
+		Expect(err).To(Equal(context.DeadlineExceeded))
+
+		ctx2, cancel2 := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel2()
+		err = store.PutCtx(ctx2, &gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		_, _, err = store.GetCtx(ctx2, "k1")
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		Expect(store.DelCtx(ctx2, "k1")).To(Equal(context.DeadlineExceeded))
+		Expect(store.DelIfMatchCtx(ctx2, "k1", "1")).To(Equal(context.DeadlineExceeded))
+		Expect(store.PutIfMatchCtx(ctx2, &gostore.Item{Key: "k1", ID: "2"}, "1", 0)).To(Equal(context.DeadlineExceeded))
+		Expect(store.Txn(func(tx *gostore.Txn) error {
+			_, _, err := tx.GetCtx(ctx2, "k1")
+			return err
+		})).To(Equal(context.DeadlineExceeded))
+
+		close(release)
+
+		_, found, err := store.Get("slow")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		_, found, _ = store.Get("k1")
+		Expect(found).To(BeFalse())
+	})
+
+	It("the rest of the API should have Ctx forms", func() {
+		ctx := context.Background()
+		_, err := store.HSetCtx(ctx, "h1", "f1", "v1")
+		Expect(err).To(BeNil())
+		v, found, _ := store.HGetCtx(ctx, "h1", "f1")
+		Expect(found).To(BeTrue())
+		Expect(v).To(Equal("v1"))
+
+		_, err = store.ZAddCtx(ctx, "z1", "a", 1, "a data")
+		Expect(err).To(BeNil())
+		members, _ := store.ZRangeByScoreCtx(ctx, "z1", 0, 10)
+		Expect(members).To(HaveLen(1))
+
+		n, err := store.LPushCtx(ctx, "q1", &gostore.Item{ID: "a"}, &gostore.Item{ID: "b"})
+		Expect(err).To(BeNil())
+		Expect(n).To(Equal(2))
+		items, _ := store.LRangeCtx(ctx, "q1", 0, -1)
+		Expect(items).To(HaveLen(2))
+
+		_, err = store.SAddCtx(ctx, "s1", "a", "b")
+		Expect(err).To(BeNil())
+		set, _ := store.SMembersCtx(ctx, "s1")
+		Expect(set).To(ConsistOf("a", "b"))
+
+		c, _ := store.IncrCtx(ctx, "c1")
+		Expect(c).To(Equal(int64(1)))
+		found, _ = store.ExpireCtx(ctx, "c1", time.Minute)
+		Expect(found).To(BeTrue())
+
+		Expect(store.ApplyCtx(ctx, new(gostore.Batch).Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0))).To(BeNil())
+		Expect(store.TxnCtx(ctx, func(tx *gostore.Txn) error {
+			if err := tx.Watch("k1"); err != nil {
+				return err
+			}
+			tx.Del("k1")
+			return nil
+		})).To(BeNil())
+		keys, _ := store.KeysCtx(ctx, "*")
+		Expect(keys).To(ConsistOf("c1", "h1", "s1"))
+
+		stats, err := store.StatsCtx(ctx)
+		Expect(err).To(BeNil())
+		Expect(stats.Items).To(Equal(3))
+		Expect(stats.Lists).To(Equal(2))
+	})
+
+	It("a cancelled context should fail the rest of the API without applying it", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := store.HSetCtx(ctx, "h1", "f1", "v1")
+		Expect(err).To(Equal(context.Canceled))
+		_, err = store.ZAddCtx(ctx, "z1", "a", 1, nil)
+		Expect(err).To(Equal(context.Canceled))
+		_, err = store.LPushCtx(ctx, "q1", &gostore.Item{ID: "a"})
+		Expect(err).To(Equal(context.Canceled))
+		_, err = store.SAddCtx(ctx, "s1", "a")
+		Expect(err).To(Equal(context.Canceled))
+		_, err = store.IncrCtx(ctx, "c1")
+		Expect(err).To(Equal(context.Canceled))
+		err = store.ApplyCtx(ctx, new(gostore.Batch).Put(&gostore.Item{Key: "k1", ID: "1"}, 0))
+		Expect(err).To(Equal(context.Canceled))
+		ran := false
+		err = store.TxnCtx(ctx, func(tx *gostore.Txn) error {
+			ran = true
+			return nil
+		})
+		Expect(err).To(Equal(context.Canceled))
+		Expect(ran).To(BeFalse())
+		_, _, err = store.ScanCtx(ctx, gostore.ScanOptions{})
+		Expect(err).To(Equal(context.Canceled))
+		_, _, err = store.BlockingPop(ctx, []string{"q1"})
+		Expect(err).To(Equal(context.Canceled))
+
+		keys, _ := store.Keys("*")
+		Expect(keys).To(BeEmpty())
+		stats, _ := store.Stats()
+		Expect(stats.Lists).To(Equal(0))
+	})
+
+	It("the deadline should be honoured while waiting to pause a busy store", func() {
+		release := make(chan struct{})
+		go store.Put(&gostore.Item{Key: "slow", ID: "1", Value: stall{release: release}}, 0)
+		time.Sleep(10 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		err := store.ApplyCtx(ctx, new(gostore.Batch).Put(&gostore.Item{Key: "k1", ID: "1"}, 0))
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		Expect(store.TxnCtx(ctx, func(tx *gostore.Txn) error {
+			return tx.Watch("k1")
+		})).To(Equal(context.DeadlineExceeded))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+
+		close(release)
+
+		_, found, err := store.Get("k1")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeFalse())
+	})
+
+})
@@ -0,0 +1,145 @@
+package gostore
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// applyIncr adds the delta of the request to the number held by the item for
+// the key, creating the item with the value 0 if it does not exist
+func (s *kvStore) applyIncr(r incrReq, now time.Time) incrResp {
+	if i, ok := s.kval[r.key]; ok && s.isExpired(i, now) {
+		s.expireItems(now)
+	}
+	old, exists := s.kval[r.key]
+	if exists && old.kind != kindPlain {
+		return incrResp{err: ErrWrongKind}
+	}
+
+	var value interface{}
+	if r.float {
+		var f float64
+		if exists {
+			var ok bool
+			if f, ok = toFloat64(old.Value); !ok {
+				return incrResp{err: &NotNumericError{Key: r.key, Value: old.Value}}
+			}
+		}
+		f += r.deltaFloat
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return incrResp{err: fmt.Errorf("increment would produce NaN or Infinity")}
+		}
+		value = f
+	} else {
+		var n int64
+		if exists {
+			var ok bool
+			if n, ok = toInt64(old.Value); !ok {
+				return incrResp{err: &NotNumericError{Key: r.key, Value: old.Value, integer: true}}
+			}
+		}
+		sum, err := addInt64(n, r.delta)
+		if err != nil {
+			return incrResp{err: err}
+		}
+		value = sum
+	}
+
+	i := old
+	if !exists {
+		i = newPutItem(&Item{Key: r.key}, PutOptions{TTL: r.ttl}, now)
+	}
+	i.Value = value
+	if err := s.makeRoom(r.key, s.estimate.itemSize(&i), now); err != nil {
+		return incrResp{err: err}
+	}
+	if err := s.wlog.append(itemRecord(opPut, r.key, &i)); err != nil {
+		return incrResp{err: err}
+	}
+	s.modified(&i)
+	s.setItem(i)
+	if exists {
+		s.events.publish(EventOverwrite, r.key, &old, &i)
+	} else {
+		s.events.publish(EventPut, r.key, nil, &i)
+	}
+	return incrResp{value: value}
+}
+
+// toInt64 converts a value of any integer type to int64
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint:
+		return int64(n), uint64(n) <= math.MaxInt64
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint64:
+		return int64(n), n <= math.MaxInt64
+	}
+	return 0, false
+}
+
+// toFloat64 converts a value of any integer or floating point type to float64
+func toFloat64(v interface{}) (float64, bool) {
+	switch f := v.(type) {
+	case float32:
+		return float64(f), true
+	case float64:
+		return f, true
+	case uint:
+		return float64(f), true
+	case uint64:
+		return float64(f), true
+	}
+	n, ok := toInt64(v)
+	return float64(n), ok
+}
+
+// addInt64 returns n + delta or an error if the sum overflows
+func addInt64(n, delta int64) (int64, error) {
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, fmt.Errorf("increment would overflow")
+	}
+	return n + delta, nil
+}
+
+// runIncr sends an increment to the event loop
+func (s *kvStore) runIncr(ctx context.Context, req incrReq) (interface{}, error) {
+	if len(req.key) == 0 {
+		return nil, fmt.Errorf("invalid input")
+	}
+	req.resp = make(chan incrResp, 1)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	select {
+	case s.incr <- req:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
+		return nil, fmt.Errorf("Incr channel timeout")
+	}
+	var r incrResp
+	select {
+	case r = <-req.resp:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return r.value, r.err
+}
@@ -0,0 +1,125 @@
+package gostore_test
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/tonjun/gostore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Counters", func() {
+
+	var store gostore.Store
+
+	BeforeEach(func() {
+		store = gostore.NewStore()
+		store.Init()
+	})
+
+	AfterEach(func() {
+		store.Close()
+	})
+
+	It("Incr(), IncrBy() and Decr() should create the key at zero", func() {
+		Expect(store.Incr("c")).To(Equal(int64(1)))
+		Expect(store.IncrBy("c", 10)).To(Equal(int64(11)))
+		Expect(store.Decr("c")).To(Equal(int64(10)))
+		Expect(store.Decr("d")).To(Equal(int64(-1)))
+
+		item, found, err := store.Get("c")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(item.Value).To(Equal(int64(10)))
+	})
+
+	It("IncrBy() should accept items holding any integer type", func() {
+		store.Put(&gostore.Item{Key: "c", ID: "1", Value: 5}, 0)
+		Expect(store.IncrBy("c", 2)).To(Equal(int64(7)))
+
+		store.Put(&gostore.Item{Key: "c", ID: "1", Value: uint8(5)}, 0)
+		Expect(store.Incr("c")).To(Equal(int64(6)))
+	})
+
+	It("Concurrent increments should not lose updates", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					_, err := store.Incr("c")
+					Expect(err).To(BeNil())
+				}
+			}()
+		}
+		wg.Wait()
+
+		item, _, _ := store.Get("c")
+		Expect(item.Value).To(Equal(int64(100)))
+	})
+
+	It("IncrByFloat() should convert integers to float64", func() {
+		Expect(store.IncrByFloat("f", 1.5)).To(Equal(1.5))
+		Expect(store.IncrByFloat("f", 1)).To(Equal(2.5))
+
+		store.Put(&gostore.Item{Key: "n", ID: "1", Value: 3}, 0)
+		Expect(store.IncrByFloat("n", 0.5)).To(Equal(3.5))
+
+		_, err := store.IncrByFloat("f", math.Inf(1))
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("Increments should return a *NotNumericError for other values", func() {
+		store.Put(&gostore.Item{Key: "s", ID: "1", Value: "ten"}, 0)
+		_, err := store.Incr("s")
+		Expect(gostore.IsNotNumeric(err)).To(BeTrue())
+		Expect(err.(*gostore.NotNumericError).Key).To(Equal("s"))
+		Expect(err.(*gostore.NotNumericError).Value).To(Equal("ten"))
+
+		_, err = store.IncrByFloat("s", 1)
+		Expect(gostore.IsNotNumeric(err)).To(BeTrue())
+
+		store.IncrByFloat("f", 1.5)
+		_, err = store.Incr("f")
+		Expect(gostore.IsNotNumeric(err)).To(BeTrue())
+
+		item, _, _ := store.Get("s")
+		Expect(item.Value).To(Equal("ten"))
+	})
+
+	It("IncrBy() should fail instead of overflowing", func() {
+		store.IncrBy("c", math.MaxInt64)
+		_, err := store.Incr("c")
+		Expect(err).NotTo(BeNil())
+		Expect(store.Decr("c")).To(Equal(int64(math.MaxInt64 - 1)))
+	})
+
+	It("IncrByWithTTL() should only set the TTL when it creates the counter", func() {
+		Expect(store.IncrByWithTTL("window", 1, 100*time.Millisecond)).To(Equal(int64(1)))
+		ttl, found, _ := store.TTL("window")
+		Expect(found).To(BeTrue())
+		Expect(ttl).To(BeNumerically(">", 0))
+
+		Expect(store.IncrByWithTTL("window", 1, time.Hour)).To(Equal(int64(2)))
+		ttl, _, _ = store.TTL("window")
+		Expect(ttl).To(BeNumerically("<=", 100*time.Millisecond))
+
+		Eventually(func() bool {
+			_, found, _ := store.Get("window")
+			return found
+		}).Should(BeFalse())
+		Expect(store.IncrByWithTTL("window", 1, time.Hour)).To(Equal(int64(1)))
+	})
+
+	It("Counters should not share a key with hashes", func() {
+		store.HSet("h", "f", 1)
+		_, err := store.Incr("h")
+		Expect(err).To(Equal(gostore.ErrWrongKind))
+	})
+
+})
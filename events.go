@@ -0,0 +1,596 @@
+package gostore
+
+import (
+	"context"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventKind is the kind of change reported by an Event. Kinds can be combined
+// into a mask in an EventFilter.
+type EventKind int
+
+const (
+	// EventPut is reported when an item is saved under a new key
+	EventPut EventKind = 1 << iota
+
+	// EventOverwrite is reported when an item replaces an existing item
+	EventOverwrite
+
+	// EventDel is reported when an item is deleted
+	EventDel
+
+	// EventExpire is reported when an item expires
+	EventExpire
+
+	// EventListPush is reported when an item is pushed to a list or a member
+	// is added to or updated in a sorted set
+	EventListPush
+
+	// EventListDel is reported when an item is removed from a list or a
+	// sorted set
+	EventListDel
+
+	// EventListExpire is reported when a list member expires
+	EventListExpire
+
+	// EventBatch is reported once for the changes applied by a Batch. Its
+	// Changes are the individual changes matching the Prefix and Pattern of
+	// the filter. Filters that do not select EventBatch receive the
+	// individual changes instead.
+	EventBatch
+
+	// EventHSet is reported when a field of a hash is set. Old and New hold
+	// the field in ID and its value in Value.
+	EventHSet
+
+	// EventHDel is reported when a field is removed from a hash
+	EventHDel
+
+	// EventSAdd is reported when a member is added to a set. New holds the
+	// member in ID.
+	EventSAdd
+
+	// EventSRem is reported when a member is removed from a set. Old holds
+	// the member in ID.
+	EventSRem
+
+	// EventEvict is reported when an item is evicted to keep the store within
+	// its MaxItems or MaxBytes limit
+	EventEvict
+)
+
+// Event describes a change to the store. For list events Key is the key of
+// the list and the items are the members that were added or removed.
+type Event struct {
+	Kind EventKind
+	Key  string
+	Old  *Item // the item before the change, nil if there was none
+	New  *Item // the item after the change, nil if it was removed
+	Time time.Time
+
+	Changes []Event // the changes applied by a Batch for EventBatch
+
+	items   []*Item   // the contents of the list for eventListChange
+	diff    *ListDiff // the change to the list for eventListDiff
+	members []ZMember // the contents of the sorted set for eventZSetChange
+}
+
+// ListOp is the operation that changed a list
+type ListOp int
+
+const (
+	// ListOpPush is reported for ListPush and ListPushWithTTL
+	ListOpPush ListOp = iota + 1
+
+	// ListOpDel is reported for ListDel
+	ListOpDel
+
+	// ListOpExpire is reported when list members expire
+	ListOpExpire
+
+	// ListOpLPush is reported for LPush
+	ListOpLPush
+
+	// ListOpRPush is reported for RPush
+	ListOpRPush
+
+	// ListOpLPop is reported for LPop and for BlockingPop on an insertion
+	// ordered list
+	ListOpLPop
+
+	// ListOpRPop is reported for RPop
+	ListOpRPop
+
+	// ListOpTrim is reported for LTrim
+	ListOpTrim
+
+	// ListOpInsert is reported for LInsert
+	ListOpInsert
+)
+
+// ListDiff describes a change to a list. Pushing an item with the ID of an
+// existing member reports the old member as removed and the new one as added.
+type ListDiff struct {
+	Key     string
+	Op      ListOp
+	Added   []*Item
+	Removed []*Item
+}
+
+// EventFilter selects the events delivered by Watch. The zero value selects
+// every event.
+type EventFilter struct {
+	// Kinds is a mask of the kinds of events to deliver, 0 for all kinds
+	Kinds EventKind
+
+	// Prefix only selects events for keys starting with Prefix
+	Prefix string
+
+	// Pattern only selects events for keys matching the glob pattern, using
+	// the syntax of path.Match
+	Pattern string
+}
+
+func (f *EventFilter) match(e *Event) bool {
+	if f.Kinds != 0 && f.Kinds&e.Kind == 0 {
+		return false
+	}
+	return f.matchKey(e.Key)
+}
+
+func (f *EventFilter) matchKey(key string) bool {
+	if !strings.HasPrefix(key, f.Prefix) {
+		return false
+	}
+	if len(f.Pattern) > 0 {
+		if ok, _ := path.Match(f.Pattern, key); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// eventListChange is reported to OnListDidChange callbacks with the new
+// contents of the list. It is not delivered to Watch.
+const eventListChange EventKind = 1 << 30
+
+// eventListDiff is reported to OnListDiff callbacks with the members added
+// to and removed from a list. It is not delivered to Watch.
+const eventListDiff EventKind = 1 << 29
+
+// eventZSetChange is reported to OnZSetDidChange callbacks with the new
+// contents of a sorted set. It is not delivered to Watch.
+const eventZSetChange EventKind = 1 << 28
+
+// publicEvents is the mask of the kinds delivered to Watch
+const publicEvents = EventPut | EventOverwrite | EventDel | EventExpire |
+	EventListPush | EventListDel | EventListExpire | EventBatch |
+	EventHSet | EventHDel | EventSAdd | EventSRem | EventEvict
+
+// OverflowPolicy decides what happens to an event when the queue of a
+// subscriber is full
+type OverflowPolicy int
+
+const (
+	// OverflowGrow queues the event past QueueSize, so no event is lost and
+	// the store never waits for the subscriber. The queue of a subscriber
+	// that stops reading grows without limit. It is the default.
+	OverflowGrow OverflowPolicy = iota
+
+	// OverflowDropOldest drops the oldest queued event to make room
+	OverflowDropOldest
+
+	// OverflowDisconnect removes the subscriber. Events already queued are
+	// still delivered.
+	OverflowDisconnect
+
+	// OverflowBlock makes the store wait until the subscriber has room in its
+	// queue. The wait is bounded: the store waits no longer than its send
+	// timeout and drops the event if the queue is still full by then, so
+	// OverflowBlock is not lossless.
+	OverflowBlock
+)
+
+// DefaultQueueSize is the queue size used when DeliveryOptions.QueueSize is 0
+const DefaultQueueSize = 1024
+
+// DeliveryOptions control how events are queued for a subscriber. Every
+// subscriber receives its events in the order they were applied.
+type DeliveryOptions struct {
+	// QueueSize is the maximum number of events waiting to be delivered
+	// before Overflow applies
+	QueueSize int
+
+	// Overflow decides what happens when the queue is full
+	Overflow OverflowPolicy
+}
+
+// eventHub delivers the events published by the event loops to subscribers
+type eventHub struct {
+	mu      sync.Mutex
+	subs    map[*subscription]bool
+	done    chan bool
+	dropped uint64
+	clock   Clock         // stamps the events
+	wait    time.Duration // the longest OverflowBlock holds up an event loop
+	held    int32         // OverflowBlock does not wait while held > 0
+}
+
+func newEventHub(clock Clock, wait time.Duration) *eventHub {
+	return &eventHub{
+		subs:  make(map[*subscription]bool),
+		done:  make(chan bool),
+		clock: clock,
+		wait:  wait,
+	}
+}
+
+// subscription queues the events matching its filter for delivery by a
+// single goroutine, so they are handled in the order they were published
+type subscription struct {
+	filter   EventFilter
+	opts     DeliveryOptions
+	handle   func(ctx context.Context, e Event)
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    []Event
+	closed   bool // no more events are accepted
+	aborted  bool // queued events are discarded
+	finished chan bool
+}
+
+// subscribe registers handle to be called with the events matching filter
+// until ctx is done
+func (h *eventHub) subscribe(ctx context.Context, filter EventFilter, opts DeliveryOptions, handle func(context.Context, Event)) *subscription {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	sub := &subscription{
+		filter:   filter,
+		opts:     opts,
+		handle:   handle,
+		finished: make(chan bool),
+	}
+	sub.cond = sync.NewCond(&sub.mu)
+
+	h.mu.Lock()
+	h.subs[sub] = true
+	h.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-h.done:
+		case <-sub.finished:
+		}
+		sub.abort()
+	}()
+	go h.deliver(ctx, sub)
+	return sub
+}
+
+// watch returns a channel receiving the events matching filter until ctx is done
+func (h *eventHub) watch(ctx context.Context, filter EventFilter, opts DeliveryOptions) <-chan Event {
+	out := make(chan Event)
+	if filter.Kinds == 0 {
+		filter.Kinds = publicEvents
+	}
+	filter.Kinds &= publicEvents
+	sub := h.subscribe(ctx, filter, opts, func(ctx context.Context, e Event) {
+		select {
+		case out <- e:
+		case <-ctx.Done():
+		case <-h.done:
+		}
+	})
+	go func() {
+		<-sub.finished
+		close(out)
+	}()
+	return out
+}
+
+func (h *eventHub) deliver(ctx context.Context, sub *subscription) {
+	defer func() {
+		h.mu.Lock()
+		delete(h.subs, sub)
+		h.mu.Unlock()
+		close(sub.finished)
+	}()
+
+	for {
+		e, ok := sub.next()
+		if !ok {
+			return
+		}
+		sub.handle(ctx, e)
+	}
+}
+
+// next waits for the next queued event
+func (sub *subscription) next() (Event, bool) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	for len(sub.queue) == 0 && !sub.closed {
+		sub.cond.Wait()
+	}
+	if len(sub.queue) == 0 || sub.aborted {
+		sub.queue = nil
+		sub.closed = true
+		sub.cond.Broadcast()
+		return Event{}, false
+	}
+	e := sub.queue[0]
+	sub.queue[0] = Event{}
+	sub.queue = sub.queue[1:]
+	sub.cond.Broadcast()
+	return e, true
+}
+
+// enqueue adds the event to the queue applying the overflow policy and
+// returns the number of events dropped. OverflowBlock waits for room for up
+// to wait, so a subscriber that stops reading cannot freeze the store. If
+// wait is 0 it lets the queue grow instead.
+func (sub *subscription) enqueue(e Event, wait time.Duration) uint64 {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.closed {
+		return 0
+	}
+	if len(sub.queue) >= sub.opts.QueueSize {
+		switch sub.opts.Overflow {
+		case OverflowDisconnect:
+			sub.closed = true
+			sub.cond.Broadcast()
+			return 1
+		case OverflowBlock:
+			if wait <= 0 {
+				break
+			}
+			timedOut := false
+			timer := time.AfterFunc(wait, func() {
+				sub.mu.Lock()
+				defer sub.mu.Unlock()
+				timedOut = true
+				sub.cond.Broadcast()
+			})
+			for len(sub.queue) >= sub.opts.QueueSize && !sub.closed && !timedOut {
+				sub.cond.Wait()
+			}
+			timer.Stop()
+			if sub.closed || len(sub.queue) >= sub.opts.QueueSize {
+				return 1
+			}
+		case OverflowDropOldest:
+			sub.queue[0] = Event{}
+			sub.queue = append(sub.queue[1:], e)
+			sub.cond.Broadcast()
+			return 1
+		}
+	}
+	sub.queue = append(sub.queue, e)
+	sub.cond.Broadcast()
+	return 0
+}
+
+// abort discards the queued events and stops the delivery
+func (sub *subscription) abort() {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	sub.closed = true
+	sub.aborted = true
+	sub.cond.Broadcast()
+}
+
+// wants returns true if any subscriber selects events of kind for key. It is
+// safe to call on a nil hub.
+func (h *eventHub) wants(kind EventKind, key string) bool {
+	if h == nil {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e := Event{Kind: kind, Key: key}
+	for sub := range h.subs {
+		if sub.filter.match(&e) {
+			return true
+		}
+	}
+	return false
+}
+
+// publish queues the event for every subscriber whose filter matches it. It
+// is safe to call on a nil hub.
+func (h *eventHub) publish(kind EventKind, key string, oldItem, newItem *Item) {
+	h.dispatch(Event{
+		Kind: kind,
+		Key:  key,
+		Old:  oldItem,
+		New:  newItem,
+	})
+}
+
+// publishListChange queues the new contents of the list for the
+// OnListDidChange callbacks
+func (h *eventHub) publishListChange(key string, items []*Item) {
+	h.dispatch(Event{
+		Kind:  eventListChange,
+		Key:   key,
+		items: items,
+	})
+}
+
+// publishZSetChange queues the new contents of the sorted set for the
+// OnZSetDidChange callbacks
+func (h *eventHub) publishZSetChange(key string, members []ZMember) {
+	h.dispatch(Event{
+		Kind:    eventZSetChange,
+		Key:     key,
+		members: members,
+	})
+}
+
+// publishListDiff queues the change to the list for the OnListDiff callbacks
+func (h *eventHub) publishListDiff(diff *ListDiff) {
+	h.dispatch(Event{
+		Kind: eventListDiff,
+		Key:  diff.Key,
+		diff: diff,
+	})
+}
+
+// publishBatch queues a single EventBatch with the changes for the
+// subscribers selecting EventBatch and the individual changes for the others
+func (h *eventHub) publishBatch(changes []Event) {
+	if h == nil || len(changes) == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	var subs []*subscription
+	for sub := range h.subs {
+		subs = append(subs, sub)
+	}
+	h.mu.Unlock()
+
+	now := h.clock.Now()
+	wait := h.waitFor()
+	for _, sub := range subs {
+		var matched []Event
+		for _, c := range changes {
+			if sub.filter.Kinds&EventBatch != 0 {
+				if !sub.filter.matchKey(c.Key) {
+					continue
+				}
+			} else if !sub.filter.match(&c) {
+				continue
+			}
+			c.Old = copyItem(c.Old)
+			c.New = copyItem(c.New)
+			c.Time = now
+			matched = append(matched, c)
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		if sub.filter.Kinds&EventBatch != 0 {
+			matched = []Event{{Kind: EventBatch, Time: now, Changes: matched}}
+		}
+		for _, e := range matched {
+			if n := sub.enqueue(e, wait); n > 0 {
+				atomic.AddUint64(&h.dropped, n)
+			}
+		}
+	}
+}
+
+func (h *eventHub) dispatch(e Event) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	var subs []*subscription
+	for sub := range h.subs {
+		if sub.filter.match(&e) {
+			subs = append(subs, sub)
+		}
+	}
+	h.mu.Unlock()
+
+	if len(subs) == 0 {
+		return
+	}
+	e.Time = h.clock.Now()
+	wait := h.waitFor()
+	for _, sub := range subs {
+		// every subscriber gets its own copy of the items
+		se := e
+		se.Old = copyItem(e.Old)
+		se.New = copyItem(e.New)
+		se.items = copyItems(e.items)
+		se.diff = copyDiff(e.diff)
+		if e.members != nil {
+			se.members = append([]ZMember{}, e.members...)
+		}
+		if n := sub.enqueue(se, wait); n > 0 {
+			atomic.AddUint64(&h.dropped, n)
+		}
+	}
+}
+
+// holdWaits stops OverflowBlock from waiting until releaseWaits is called. It
+// is safe to call on a nil hub.
+func (h *eventHub) holdWaits() {
+	if h != nil {
+		atomic.AddInt32(&h.held, 1)
+	}
+}
+
+// releaseWaits undoes holdWaits. It is safe to call on a nil hub.
+func (h *eventHub) releaseWaits() {
+	if h != nil {
+		atomic.AddInt32(&h.held, -1)
+	}
+}
+
+// waitFor returns how long OverflowBlock may wait for room in a queue
+func (h *eventHub) waitFor() time.Duration {
+	if atomic.LoadInt32(&h.held) > 0 {
+		return 0
+	}
+	return h.wait
+}
+
+// droppedEvents returns the number of events dropped by the overflow policies
+func (h *eventHub) droppedEvents() uint64 {
+	return atomic.LoadUint64(&h.dropped)
+}
+
+// closeHub ends every subscription
+func (h *eventHub) closeHub() {
+	if h == nil {
+		return
+	}
+	close(h.done)
+}
+
+func copyItem(i *Item) *Item {
+	if i == nil {
+		return nil
+	}
+	c := *i
+	return &c
+}
+
+func copyItems(items []*Item) []*Item {
+	if items == nil {
+		return nil
+	}
+	c := make([]*Item, len(items))
+	for n, i := range items {
+		c[n] = copyItem(i)
+	}
+	return c
+}
+
+func copyDiff(d *ListDiff) *ListDiff {
+	if d == nil {
+		return nil
+	}
+	return &ListDiff{
+		Key:     d.Key,
+		Op:      d.Op,
+		Added:   copyItems(d.Added),
+		Removed: copyItems(d.Removed),
+	}
+}
@@ -0,0 +1,310 @@
+package gostore_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tonjun/gostore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Events", func() {
+
+	var store gostore.Store
+	var ctx context.Context
+	var cancel context.CancelFunc
+
+	BeforeEach(func() {
+		store = gostore.NewStore()
+		store.Init()
+		ctx, cancel = context.WithCancel(context.Background())
+	})
+
+	AfterEach(func() {
+		cancel()
+		store.Close()
+	})
+
+	It("Watch() should receive an event for every mutation", func() {
+		events := store.Watch(ctx, gostore.EventFilter{})
+
+		store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)
+		store.Put(&gostore.Item{Key: "k1", ID: "2", Value: "v2"}, 0)
+		store.Del("k1")
+		store.ListPush("l1", &gostore.Item{ID: "a", Value: "a data"})
+		store.ListDel("l1", &gostore.Item{ID: "a"})
+
+		var e gostore.Event
+		Eventually(events).Should(Receive(&e))
+		Expect(e.Kind).To(Equal(gostore.EventPut))
+		Expect(e.Key).To(Equal("k1"))
+		Expect(e.Old).To(BeNil())
+		Expect(e.New.Value).To(Equal("v1"))
+
+		Eventually(events).Should(Receive(&e))
+		Expect(e.Kind).To(Equal(gostore.EventOverwrite))
+		Expect(e.Old.Value).To(Equal("v1"))
+		Expect(e.New.Value).To(Equal("v2"))
+
+		Eventually(events).Should(Receive(&e))
+		Expect(e.Kind).To(Equal(gostore.EventDel))
+		Expect(e.Old.Value).To(Equal("v2"))
+		Expect(e.New).To(BeNil())
+
+		Eventually(events).Should(Receive(&e))
+		Expect(e.Kind).To(Equal(gostore.EventListPush))
+		Expect(e.Key).To(Equal("l1"))
+		Expect(e.New.ID).To(Equal("a"))
+
+		Eventually(events).Should(Receive(&e))
+		Expect(e.Kind).To(Equal(gostore.EventListDel))
+		Expect(e.Old.ID).To(Equal("a"))
+		Expect(e.Time.IsZero()).To(BeFalse())
+	})
+
+	It("Watch() should report expired items", func() {
+		events := store.Watch(ctx, gostore.EventFilter{Kinds: gostore.EventExpire | gostore.EventListExpire})
+
+		store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 50*time.Millisecond)
+		store.ListPushWithTTL("l1", &gostore.Item{ID: "a", Value: "a data"}, 100*time.Millisecond)
+
+		var e gostore.Event
+		Eventually(events, "1s").Should(Receive(&e))
+		Expect(e.Kind).To(Equal(gostore.EventExpire))
+		Expect(e.Old.Key).To(Equal("k1"))
+		Eventually(events, "1s").Should(Receive(&e))
+		Expect(e.Kind).To(Equal(gostore.EventListExpire))
+		Expect(e.Old.ID).To(Equal("a"))
+	})
+
+	It("Watch() should filter events by kind, prefix and pattern", func() {
+		byKind := store.Watch(ctx, gostore.EventFilter{Kinds: gostore.EventDel})
+		byPrefix := store.Watch(ctx, gostore.EventFilter{Prefix: "user:"})
+		byPattern := store.Watch(ctx, gostore.EventFilter{Pattern: "user:*:session"})
+
+		store.Put(&gostore.Item{Key: "user:1:profile", ID: "1", Value: "v1"}, 0)
+		store.Put(&gostore.Item{Key: "user:1:session", ID: "1", Value: "v1"}, 0)
+		store.Put(&gostore.Item{Key: "other", ID: "1", Value: "v1"}, 0)
+		store.Del("other")
+
+		var e gostore.Event
+		Eventually(byKind).Should(Receive(&e))
+		Expect(e.Key).To(Equal("other"))
+		Consistently(byKind).ShouldNot(Receive())
+
+		Eventually(byPrefix).Should(Receive(&e))
+		Expect(e.Key).To(Equal("user:1:profile"))
+		Eventually(byPrefix).Should(Receive(&e))
+		Expect(e.Key).To(Equal("user:1:session"))
+		Consistently(byPrefix).ShouldNot(Receive())
+
+		Eventually(byPattern).Should(Receive(&e))
+		Expect(e.Key).To(Equal("user:1:session"))
+		Consistently(byPattern).ShouldNot(Receive())
+	})
+
+	It("The channel should be closed when the context is done", func() {
+		events := store.Watch(ctx, gostore.EventFilter{})
+		cancel()
+		Eventually(events).Should(BeClosed())
+	})
+
+	It("Callbacks should receive the changes of a list in order", func() {
+		lengths := make(chan int, 100)
+		store.OnListDidChange(func(key string, items []*gostore.Item) {
+			lengths <- len(items)
+		})
+
+		for i := 0; i < 50; i++ {
+			store.ListPush("l1", &gostore.Item{ID: fmt.Sprintf("%03d", i), Value: i})
+		}
+		for i := 1; i <= 50; i++ {
+			Eventually(lengths).Should(Receive(Equal(i)))
+		}
+	})
+
+	It("Every callback should receive its own copy of the changes", func() {
+		mutated := make(chan bool)
+		store.OnListDiff(func(diff gostore.ListDiff) {
+			diff.Added[0].Value = "changed"
+			diff.Added[0] = nil
+			close(mutated)
+		})
+		diffs := make(chan gostore.ListDiff, 1)
+		store.OnListDiff(func(diff gostore.ListDiff) {
+			<-mutated
+			diffs <- diff
+		})
+
+		store.ListPush("l1", &gostore.Item{ID: "a", Value: "a data"})
+
+		var d gostore.ListDiff
+		Eventually(diffs).Should(Receive(&d))
+		Expect(d.Added[0]).NotTo(BeNil())
+		Expect(d.Added[0].Value).To(Equal("a data"))
+	})
+
+	It("OnListDiff() should receive the members added and removed", func() {
+		diffs := make(chan gostore.ListDiff, 10)
+		store.OnListDiff(func(diff gostore.ListDiff) {
+			diffs <- diff
+		})
+
+		store.ListPush("l1", &gostore.Item{ID: "a", Value: "a data"})
+		store.ListPush("l1", &gostore.Item{ID: "a", Value: "a data 2"})
+		store.ListDel("l1", &gostore.Item{ID: "a"})
+		store.ListPushWithTTL("l1", &gostore.Item{ID: "b", Value: "b data"}, 50*time.Millisecond)
+
+		var d gostore.ListDiff
+		Eventually(diffs).Should(Receive(&d))
+		Expect(d.Key).To(Equal("l1"))
+		Expect(d.Op).To(Equal(gostore.ListOpPush))
+		Expect(d.Added).To(HaveLen(1))
+		Expect(d.Added[0].Value).To(Equal("a data"))
+		Expect(d.Removed).To(BeEmpty())
+
+		Eventually(diffs).Should(Receive(&d))
+		Expect(d.Op).To(Equal(gostore.ListOpPush))
+		Expect(d.Added[0].Value).To(Equal("a data 2"))
+		Expect(d.Removed).To(HaveLen(1))
+		Expect(d.Removed[0].Value).To(Equal("a data"))
+
+		Eventually(diffs).Should(Receive(&d))
+		Expect(d.Op).To(Equal(gostore.ListOpDel))
+		Expect(d.Added).To(BeEmpty())
+		Expect(d.Removed[0].Value).To(Equal("a data 2"))
+
+		Eventually(diffs).Should(Receive(&d))
+		Expect(d.Op).To(Equal(gostore.ListOpPush))
+		Expect(d.Added[0].ID).To(Equal("b"))
+
+		Eventually(diffs, "1s").Should(Receive(&d))
+		Expect(d.Op).To(Equal(gostore.ListOpExpire))
+		Expect(d.Removed[0].ID).To(Equal("b"))
+		Expect(d.Removed[0].ExpireReason()).To(Equal(gostore.ExpiredDeadline))
+	})
+
+	It("OverflowDropOldest should drop the oldest events of a slow watcher", func() {
+		opts := gostore.DeliveryOptions{QueueSize: 2, Overflow: gostore.OverflowDropOldest}
+		events := store.WatchWithOptions(ctx, gostore.EventFilter{}, opts)
+
+		for i := 0; i < 10; i++ {
+			store.Put(&gostore.Item{Key: fmt.Sprintf("k%d", i), ID: "1", Value: i}, 0)
+		}
+		Expect(store.DroppedEvents()).To(BeNumerically(">=", 7))
+
+		var last gostore.Event
+		Eventually(func() string {
+			select {
+			case last = <-events:
+			default:
+			}
+			return last.Key
+		}).Should(Equal("k9"))
+	})
+
+	It("OverflowDisconnect should close the channel of a slow watcher", func() {
+		opts := gostore.DeliveryOptions{QueueSize: 2, Overflow: gostore.OverflowDisconnect}
+		events := store.WatchWithOptions(ctx, gostore.EventFilter{}, opts)
+
+		for i := 0; i < 10; i++ {
+			store.Put(&gostore.Item{Key: fmt.Sprintf("k%d", i), ID: "1", Value: i}, 0)
+		}
+		Expect(store.DroppedEvents()).To(BeNumerically(">", 0))
+		Eventually(events).Should(BeClosed())
+	})
+
+	It("OverflowBlock should make the store wait for a slow watcher", func() {
+		opts := gostore.DeliveryOptions{QueueSize: 1, Overflow: gostore.OverflowBlock}
+		events := store.WatchWithOptions(ctx, gostore.EventFilter{}, opts)
+
+		done := make(chan bool)
+		go func() {
+			for i := 0; i < 5; i++ {
+				store.Put(&gostore.Item{Key: fmt.Sprintf("k%d", i), ID: "1", Value: i}, 0)
+			}
+			close(done)
+		}()
+		Consistently(done).ShouldNot(BeClosed())
+
+		for i := 0; i < 5; i++ {
+			var e gostore.Event
+			Eventually(events).Should(Receive(&e))
+			Expect(e.Key).To(Equal(fmt.Sprintf("k%d", i)))
+		}
+		Eventually(done).Should(BeClosed())
+		Expect(store.DroppedEvents()).To(BeZero())
+	})
+
+	It("A watcher that stops reading should not block the store or lose events by default", func() {
+		events := store.Watch(ctx, gostore.EventFilter{})
+
+		done := make(chan bool)
+		go func() {
+			for i := 0; i < gostore.DefaultQueueSize+100; i++ {
+				store.Put(&gostore.Item{Key: fmt.Sprintf("k%d", i), ID: "1", Value: i}, 0)
+			}
+			close(done)
+		}()
+		Eventually(done, "5s").Should(BeClosed())
+		Expect(store.DroppedEvents()).To(BeZero())
+
+		for i := 0; i < gostore.DefaultQueueSize+100; i++ {
+			select {
+			case e := <-events:
+				Expect(e.Key).To(Equal(fmt.Sprintf("k%d", i)))
+			case <-time.After(time.Second):
+				Fail("the events were not delivered")
+			}
+		}
+	})
+
+	It("A slow callback should not lose events by default", func() {
+		var mu sync.Mutex
+		var keys []string
+		release := make(chan bool)
+		unsubscribe := store.OnItemDidExpire(func(item *gostore.Item) {
+			<-release
+			mu.Lock()
+			keys = append(keys, item.Key)
+			mu.Unlock()
+		})
+		defer unsubscribe()
+
+		n := gostore.DefaultQueueSize + 10
+		for i := 0; i < n; i++ {
+			store.Put(&gostore.Item{Key: fmt.Sprintf("k%d", i), ID: "1", Value: i}, time.Millisecond)
+		}
+		Eventually(func() int {
+			c, _ := store.Count("k")
+			return c
+		}, "5s").Should(BeZero())
+		close(release)
+
+		Eventually(func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(keys)
+		}, "5s").Should(Equal(n))
+		Expect(store.DroppedEvents()).To(BeZero())
+	})
+
+	It("OverflowBlock should stop waiting for a slow watcher after the send timeout", func() {
+		slow, err := gostore.New(gostore.WithSendTimeout(20 * time.Millisecond))
+		Expect(err).To(BeNil())
+		defer slow.Close()
+
+		opts := gostore.DeliveryOptions{QueueSize: 1, Overflow: gostore.OverflowBlock}
+		slow.WatchWithOptions(ctx, gostore.EventFilter{}, opts)
+
+		for i := 0; i < 5; i++ {
+			Expect(slow.Put(&gostore.Item{Key: fmt.Sprintf("k%d", i), ID: "1", Value: i}, 0)).To(BeNil())
+		}
+		Expect(slow.DroppedEvents()).To(BeNumerically(">", 0))
+	})
+
+})
@@ -0,0 +1,258 @@
+package gostore
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/btree"
+)
+
+// EvictionPolicy chooses the items removed when the store reaches its
+// MaxItems or MaxBytes limit
+type EvictionPolicy int
+
+const (
+	// NoEviction makes Put and the other writes that would exceed a limit
+	// fail with ErrMemoryLimit
+	NoEviction EvictionPolicy = iota
+
+	// EvictLRU evicts the least recently used items
+	EvictLRU
+
+	// EvictLFU evicts the least frequently used items
+	EvictLFU
+
+	// EvictVolatileLRU evicts the least recently used items among the items
+	// with an expiry. Writes fail with ErrMemoryLimit if there is none.
+	EvictVolatileLRU
+
+	// EvictRandom evicts random items
+	EvictRandom
+)
+
+// ErrMemoryLimit is returned by a write that would exceed the MaxItems or
+// MaxBytes limit when no item can be evicted
+var ErrMemoryLimit = errors.New("memory limit reached")
+
+// evictEntry orders the keys by how likely they are to be evicted
+type evictEntry struct {
+	freq uint64 // the number of accesses, only counted by EvictLFU
+	tick uint64 // when the key was last accessed
+	key  string
+}
+
+func (a evictEntry) Less(b btree.Item) bool {
+	e := b.(evictEntry)
+	if a.freq != e.freq {
+		return a.freq < e.freq
+	}
+	if a.tick != e.tick {
+		return a.tick < e.tick
+	}
+	return a.key < e.key
+}
+
+// evictor keeps track of the usage of the keys for the eviction policy. A
+// nil evictor has no limits.
+type evictor struct {
+	policy   EvictionPolicy
+	degree   int
+	maxItems int
+	maxBytes int64
+	tick     uint64
+	entries  map[string]evictEntry
+	order    *btree.BTree // the keys that can be evicted, first to go first
+}
+
+func newEvictor(cfg Config, degree int) *evictor {
+	if cfg.MaxItems <= 0 && cfg.MaxBytes <= 0 {
+		return nil
+	}
+	e := &evictor{
+		policy:   cfg.Eviction,
+		degree:   degree,
+		maxItems: cfg.MaxItems,
+		maxBytes: cfg.MaxBytes,
+	}
+	e.reset()
+	return e
+}
+
+func (e *evictor) reset() {
+	if e == nil {
+		return
+	}
+	e.entries = make(map[string]evictEntry)
+	e.order = btree.New(e.degree)
+}
+
+// over returns true if n items using the given bytes exceed the limits. It
+// is safe to call on a nil evictor.
+func (e *evictor) over(n int, bytes int64) bool {
+	if e == nil {
+		return false
+	}
+	return (e.maxItems > 0 && n > e.maxItems) || (e.maxBytes > 0 && bytes > e.maxBytes)
+}
+
+// tooLarge returns true if an item of the given size exceeds MaxBytes on its
+// own, so evicting other items can never make room for it
+func (e *evictor) tooLarge(size int64) bool {
+	return e != nil && e.maxBytes > 0 && size > e.maxBytes
+}
+
+// touch records an access to the item. It is safe to call on a nil evictor.
+func (e *evictor) touch(i *Item) {
+	if e == nil {
+		return
+	}
+	switch e.policy {
+	case EvictLRU, EvictLFU, EvictVolatileLRU:
+	default:
+		return
+	}
+	old, ok := e.entries[i.Key]
+	if ok {
+		e.order.Delete(old)
+	}
+	if e.policy == EvictVolatileLRU && i.expiresAt.IsZero() {
+		delete(e.entries, i.Key)
+		return
+	}
+	e.tick++
+	entry := evictEntry{tick: e.tick, key: i.Key}
+	if e.policy == EvictLFU {
+		entry.freq = old.freq + 1
+	}
+	e.entries[i.Key] = entry
+	e.order.ReplaceOrInsert(entry)
+}
+
+// remove forgets the key. It is safe to call on a nil evictor.
+func (e *evictor) remove(key string) {
+	if e == nil {
+		return
+	}
+	if old, ok := e.entries[key]; ok {
+		e.order.Delete(old)
+		delete(e.entries, key)
+	}
+}
+
+// victim returns the key to evict next, other than exclude
+func (e *evictor) victim(kval map[string]Item, exclude string) (string, bool) {
+	if e.policy == EvictRandom {
+		// map iteration starts at a random key
+		for key := range kval {
+			if key != exclude {
+				return key, true
+			}
+		}
+		return "", false
+	}
+	var key string
+	e.order.Ascend(func(a btree.Item) bool {
+		if k := a.(evictEntry).key; k != exclude {
+			key = k
+			return false
+		}
+		return true
+	})
+	return key, len(key) > 0
+}
+
+// fits returns true if saving an item of the given size under the key keeps
+// the store within its limits
+func (s *kvStore) fits(key string, size int64) bool {
+	n, bytes := len(s.kval), s.bytes+size
+	if old, ok := s.kval[key]; ok {
+		bytes -= old.size
+	} else {
+		n++
+	}
+	return !s.evict.over(n, bytes)
+}
+
+// makeRoom evicts items until an item of the given size can be saved under
+// the key, or returns ErrMemoryLimit if it cannot
+func (s *kvStore) makeRoom(key string, size int64, now time.Time) error {
+	if s.fits(key, size) {
+		return nil
+	}
+	if s.evict.tooLarge(size) {
+		return ErrMemoryLimit
+	}
+	s.expireItems(now)
+	for !s.fits(key, size) {
+		if s.evict.policy == NoEviction {
+			return ErrMemoryLimit
+		}
+		victim, ok := s.evict.victim(s.kval, key)
+		if !ok {
+			return ErrMemoryLimit
+		}
+		s.evictItem(victim)
+	}
+	return nil
+}
+
+// evictOverflow evicts items until the store is within its limits
+func (s *kvStore) evictOverflow() {
+	for s.evict.over(len(s.kval), s.bytes) && s.evict.policy != NoEviction {
+		victim, ok := s.evict.victim(s.kval, "")
+		if !ok {
+			return
+		}
+		s.evictItem(victim)
+	}
+}
+
+func (s *kvStore) evictItem(key string) {
+	i := s.kval[key]
+	if err := s.wlog.append(record{Op: opDel, Key: key}); err != nil {
+		s.opts.logf("ERROR: unable to log eviction of \"%s\": %v", key, err)
+	}
+	s.deleteItem(key)
+	s.events.publish(EventEvict, key, &i, nil)
+}
+
+// batchFits returns ErrMemoryLimit if the store has no eviction policy and
+// the puts and deletes of the batch would exceed its limits
+func (s *kvStore) batchFits(ops []batchOp, items []Item) error {
+	if s.evict == nil || s.evict.policy != NoEviction {
+		return nil
+	}
+	// the sizes of the keys changed by the batch, -1 once deleted
+	sizes := make(map[string]int64)
+	sizeOf := func(key string) (int64, bool) {
+		if size, ok := sizes[key]; ok {
+			return size, size >= 0
+		}
+		i, ok := s.kval[key]
+		return i.size, ok
+	}
+	n, bytes := len(s.kval), s.bytes
+	for k, op := range ops {
+		switch op.kind {
+		case batchPut:
+			key := items[k].Key
+			if old, ok := sizeOf(key); ok {
+				bytes -= old
+			} else {
+				n++
+			}
+			sizes[key] = s.estimate.itemSize(&items[k])
+			bytes += sizes[key]
+		case batchDel:
+			if old, ok := sizeOf(op.key); ok {
+				bytes -= old
+				n--
+				sizes[op.key] = -1
+			}
+		}
+	}
+	if s.evict.over(n, bytes) {
+		return ErrMemoryLimit
+	}
+	return nil
+}
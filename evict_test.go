@@ -0,0 +1,201 @@
+package gostore_test
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tonjun/gostore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Eviction", func() {
+
+	var store gostore.Store
+
+	open := func(cfg gostore.Config) {
+		store = gostore.NewStoreWithConfig(cfg)
+		store.Init()
+	}
+
+	AfterEach(func() {
+		store.Close()
+	})
+
+	put := func(key string, d time.Duration) error {
+		return store.Put(&gostore.Item{Key: key, ID: key, Value: key + " data"}, d)
+	}
+
+	found := func(key string) bool {
+		_, ok, _ := store.Get(key)
+		return ok
+	}
+
+	It("EvictLRU should evict the least recently used item", func() {
+		open(gostore.Config{MaxItems: 3, Eviction: gostore.EvictLRU})
+		evicted := make(chan *gostore.Item, 10)
+		store.OnItemEvicted(func(item *gostore.Item) {
+			evicted <- item
+		})
+
+		put("k1", 0)
+		put("k2", 0)
+		put("k3", 0)
+		store.Get("k1")
+		Expect(put("k4", 0)).To(BeNil())
+
+		var item *gostore.Item
+		Eventually(evicted).Should(Receive(&item))
+		Expect(item.Key).To(Equal("k2"))
+		Expect(found("k2")).To(BeFalse())
+		Expect(store.Count("")).To(Equal(3))
+	})
+
+	It("EvictLFU should evict the least frequently used item", func() {
+		open(gostore.Config{MaxItems: 3, Eviction: gostore.EvictLFU})
+
+		put("k1", 0)
+		put("k2", 0)
+		put("k3", 0)
+		store.Get("k1")
+		store.Get("k1")
+		store.Get("k3")
+		Expect(put("k4", 0)).To(BeNil())
+
+		Expect(found("k2")).To(BeFalse())
+		Expect(found("k1")).To(BeTrue())
+		Expect(found("k3")).To(BeTrue())
+		Expect(found("k4")).To(BeTrue())
+	})
+
+	It("EvictLFU should keep counting the accesses of a rewritten item", func() {
+		open(gostore.Config{MaxItems: 2, Eviction: gostore.EvictLFU})
+
+		put("hot", 0)
+		put("cold", 0)
+		for i := 0; i < 50; i++ {
+			store.Get("hot")
+		}
+		store.Get("cold")
+		put("hot", 0)
+		Expect(put("new", 0)).To(BeNil())
+
+		Expect(found("cold")).To(BeFalse())
+		Expect(found("hot")).To(BeTrue())
+	})
+
+	It("EvictLFU should keep counting the accesses of an item with an idle timeout", func() {
+		open(gostore.Config{MaxItems: 2, Eviction: gostore.EvictLFU})
+
+		idle := gostore.PutOptions{IdleTimeout: time.Hour}
+		store.PutWithOptions(&gostore.Item{Key: "hot", ID: "hot", Value: "hot data"}, idle)
+		store.PutWithOptions(&gostore.Item{Key: "cold", ID: "cold", Value: "cold data"}, idle)
+		for i := 0; i < 50; i++ {
+			store.Get("hot")
+		}
+		store.Get("cold")
+		store.Get("cold")
+		Expect(put("new", 0)).To(BeNil())
+
+		Expect(found("cold")).To(BeFalse())
+		Expect(found("hot")).To(BeTrue())
+	})
+
+	It("EvictVolatileLRU should only evict items with an expiry", func() {
+		open(gostore.Config{MaxItems: 3, Eviction: gostore.EvictVolatileLRU})
+
+		put("k1", 0)
+		put("k2", time.Hour)
+		put("k3", 0)
+		Expect(put("k4", 0)).To(BeNil())
+		Expect(found("k2")).To(BeFalse())
+
+		Expect(put("k5", 0)).To(Equal(gostore.ErrMemoryLimit))
+		Expect(store.Keys("*")).To(Equal([]string{"k1", "k3", "k4"}))
+	})
+
+	It("An item larger than MaxBytes should be rejected without evicting anything", func() {
+		open(gostore.Config{MaxBytes: 4096, Eviction: gostore.EvictLRU})
+		for i := 0; i < 10; i++ {
+			Expect(put(fmt.Sprint("k", i), 0)).To(BeNil())
+		}
+
+		big := &gostore.Item{Key: "big", ID: "big", Value: strings.Repeat("x", 8192)}
+		Expect(store.Put(big, 0)).To(Equal(gostore.ErrMemoryLimit))
+		Expect(store.Count("")).To(Equal(10))
+	})
+
+	It("EvictRandom should keep the store within its limit", func() {
+		open(gostore.Config{MaxItems: 5, Eviction: gostore.EvictRandom})
+
+		for i := 0; i < 20; i++ {
+			Expect(put(fmt.Sprint("k", i), 0)).To(BeNil())
+		}
+		Expect(store.Count("")).To(Equal(5))
+		Expect(found("k19")).To(BeTrue())
+	})
+
+	It("NoEviction should make writes fail once the limit is reached", func() {
+		open(gostore.Config{MaxItems: 2})
+
+		put("k1", 0)
+		put("k2", 0)
+		Expect(put("k3", 0)).To(Equal(gostore.ErrMemoryLimit))
+		_, err := store.Incr("counter")
+		Expect(err).To(Equal(gostore.ErrMemoryLimit))
+
+		// overwriting an item does not add one
+		Expect(put("k1", 0)).To(BeNil())
+
+		b := gostore.NewBatch().
+			Del("k1").
+			Put(&gostore.Item{Key: "k3", ID: "3", Value: "v"}, 0).
+			Put(&gostore.Item{Key: "k4", ID: "4", Value: "v"}, 0)
+		Expect(store.Apply(b)).To(Equal(gostore.ErrMemoryLimit))
+		Expect(found("k1")).To(BeTrue())
+
+		b = gostore.NewBatch().
+			Del("k1").
+			Put(&gostore.Item{Key: "k3", ID: "3", Value: "v"}, 0)
+		Expect(store.Apply(b)).To(BeNil())
+		Expect(store.Keys("*")).To(Equal([]string{"k2", "k3"}))
+	})
+
+	It("MaxBytes should limit the approximate size of the items", func() {
+		open(gostore.Config{MaxBytes: 2500, Eviction: gostore.EvictLRU})
+
+		value := strings.Repeat("x", 1000)
+		for i := 0; i < 5; i++ {
+			key := fmt.Sprint("k", i)
+			Expect(store.Put(&gostore.Item{Key: key, ID: key, Value: value}, 0)).To(BeNil())
+		}
+		Expect(store.Keys("*")).To(Equal([]string{"k3", "k4"}))
+
+		// a hash grows with its fields
+		store.HSet("h", "f1", value)
+		Expect(store.Keys("*")).To(Equal([]string{"h", "k4"}))
+		store.HSet("h", "f2", value)
+		Expect(store.Keys("*")).To(Equal([]string{"h"}))
+	})
+
+	It("Evicted items should not be reported as expired", func() {
+		open(gostore.Config{MaxItems: 1, Eviction: gostore.EvictLRU})
+		expired := make(chan *gostore.Item, 10)
+		store.OnItemDidExpire(func(item *gostore.Item) {
+			expired <- item
+		})
+		evicted := make(chan *gostore.Item, 10)
+		store.OnItemEvicted(func(item *gostore.Item) {
+			evicted <- item
+		})
+
+		put("k1", 50*time.Millisecond)
+		put("k2", 0)
+
+		Eventually(evicted).Should(Receive())
+		Consistently(expired, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+})
@@ -1,6 +1,7 @@
 package gostore_test
 
 import (
+	"fmt"
 	"log"
 	"time"
 
@@ -79,4 +80,213 @@ var _ = Describe("Expire", func() {
 		Consistently(ch, "1s").ShouldNot(Receive())
 	})
 
+	It("Items should expire at their deadline rather than on a fixed interval", func() {
+
+		ch := make(chan string, 10)
+
+		store.OnItemDidExpire(func(item *gostore.Item) {
+			ch <- item.Key
+		})
+
+		for i := 0; i < 1000; i++ {
+			store.Put(&gostore.Item{Key: fmt.Sprintf("later%d", i), ID: "1", Value: "data"}, 20*time.Second)
+		}
+		store.Put(&gostore.Item{Key: "second", ID: "1", Value: "data"}, 150*time.Millisecond)
+		store.Put(&gostore.Item{Key: "first", ID: "1", Value: "data"}, 50*time.Millisecond)
+
+		Eventually(ch, "100ms", "5ms").Should(Receive(Equal("first")))
+		Eventually(ch, "150ms", "5ms").Should(Receive(Equal("second")))
+		Consistently(ch, "200ms").ShouldNot(Receive())
+	})
+
+	It("Setting an item without a duration should clear its previous expiry", func() {
+
+		ch := make(chan bool)
+
+		store.OnItemDidExpire(func(item *gostore.Item) {
+			ch <- true
+		})
+
+		store.Put(&gostore.Item{Key: "keyone", ID: "1", Value: "data1"}, 100*time.Millisecond)
+		store.Put(&gostore.Item{Key: "keyone", ID: "2", Value: "data2"}, 0)
+		Consistently(ch, "300ms").ShouldNot(Receive())
+
+		_, found, _ := store.Get("keyone")
+		Expect(found).To(BeTrue())
+	})
+
+	It("List members pushed with a TTL should expire on their own", func() {
+
+		expired := make(chan string, 10)
+		changed := make(chan []*gostore.Item, 10)
+
+		store.ListPush("room", &gostore.Item{ID: "a", Value: "a data"})
+		store.ListPushWithTTL("room", &gostore.Item{ID: "b", Value: "b data"}, 100*time.Millisecond)
+		store.ListPushWithTTL("room", &gostore.Item{ID: "c", Value: "c data"}, 20*time.Second)
+
+		store.OnListItemDidExpire(func(key string, item *gostore.Item) {
+			expired <- key + "/" + item.ID
+		})
+		store.OnListDidChange(func(key string, items []*gostore.Item) {
+			changed <- items
+		})
+
+		Eventually(expired, "1s").Should(Receive(Equal("room/b")))
+		var items []*gostore.Item
+		Eventually(changed, "1s").Should(Receive(&items))
+		Expect(len(items)).To(Equal(2))
+		if len(items) == 2 {
+			Expect(items[0].ID).To(Equal("a"))
+			Expect(items[1].ID).To(Equal("c"))
+		}
+		Consistently(expired, "300ms").ShouldNot(Receive())
+	})
+
+	It("Pushing a list member again should replace its TTL", func() {
+
+		expired := make(chan string, 10)
+
+		store.OnListItemDidExpire(func(key string, item *gostore.Item) {
+			expired <- item.ID
+		})
+
+		store.ListPushWithTTL("room", &gostore.Item{ID: "a", Value: "a data"}, 100*time.Millisecond)
+		store.ListPush("room", &gostore.Item{ID: "a", Value: "a data"})
+		Consistently(expired, "300ms").ShouldNot(Receive())
+
+		items, _, _ := store.ListGet("room")
+		Expect(len(items)).To(Equal(1))
+	})
+
+	It("TTL() should report the time left before an item expires", func() {
+
+		store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "data"}, 10*time.Second)
+		store.Put(&gostore.Item{Key: "k2", ID: "2", Value: "data"}, 0)
+
+		ttl, found, err := store.TTL("k1")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(ttl).To(BeNumerically("~", 10*time.Second, time.Second))
+
+		ttl, found, err = store.TTL("k2")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(ttl).To(Equal(gostore.NoExpiry))
+
+		_, found, err = store.TTL("none")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeFalse())
+	})
+
+	It("Expire() and ExpireAt() should change the deadline of an item", func() {
+
+		ch := make(chan string, 10)
+
+		store.OnItemDidExpire(func(item *gostore.Item) {
+			ch <- item.Key
+		})
+
+		store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "data"}, 0)
+		store.Put(&gostore.Item{Key: "k2", ID: "2", Value: "data"}, 10*time.Second)
+
+		found, err := store.Expire("k1", 100*time.Millisecond)
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		found, err = store.ExpireAt("k2", time.Now().Add(300*time.Millisecond))
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		found, err = store.Expire("none", time.Second)
+		Expect(err).To(BeNil())
+		Expect(found).To(BeFalse())
+
+		Eventually(ch, "1s").Should(Receive(Equal("k1")))
+		Eventually(ch, "1s").Should(Receive(Equal("k2")))
+	})
+
+	It("Persist() should remove the expiry of an item", func() {
+
+		ch := make(chan string, 10)
+
+		store.OnItemDidExpire(func(item *gostore.Item) {
+			ch <- item.Key
+		})
+
+		store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "data"}, 100*time.Millisecond)
+		found, err := store.Persist("k1")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Consistently(ch, "300ms").ShouldNot(Receive())
+
+		ttl, _, _ := store.TTL("k1")
+		Expect(ttl).To(Equal(gostore.NoExpiry))
+	})
+
+	It("Touch() should extend the expiry by the original duration", func() {
+
+		ch := make(chan string, 10)
+
+		store.OnItemDidExpire(func(item *gostore.Item) {
+			ch <- item.Key
+		})
+
+		store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "data"}, 300*time.Millisecond)
+		for i := 0; i < 4; i++ {
+			time.Sleep(150 * time.Millisecond)
+			found, err := store.Touch("k1")
+			Expect(err).To(BeNil())
+			Expect(found).To(BeTrue())
+		}
+		Expect(ch).ShouldNot(Receive())
+
+		i, found, _ := store.Get("k1")
+		Expect(found).To(BeTrue())
+		Expect(i.Value).To(Equal("data"))
+		Eventually(ch, "1s").Should(Receive(Equal("k1")))
+	})
+
+	It("Items with an IdleTimeout should expire only after not being read", func() {
+
+		ch := make(chan *gostore.Item, 10)
+
+		store.OnItemDidExpire(func(item *gostore.Item) {
+			ch <- item
+		})
+
+		err := store.PutWithOptions(&gostore.Item{Key: "session", ID: "1", Value: "data"}, gostore.PutOptions{IdleTimeout: 300 * time.Millisecond})
+		Expect(err).To(BeNil())
+		for i := 0; i < 4; i++ {
+			time.Sleep(150 * time.Millisecond)
+			_, found, err := store.Get("session")
+			Expect(err).To(BeNil())
+			Expect(found).To(BeTrue())
+		}
+		Expect(ch).ShouldNot(Receive())
+
+		var item *gostore.Item
+		Eventually(ch, "1s").Should(Receive(&item))
+		Expect(item.Key).To(Equal("session"))
+		Expect(item.ExpireReason()).To(Equal(gostore.ExpiredIdle))
+	})
+
+	It("Items with an IdleTimeout should still expire at their TTL", func() {
+
+		ch := make(chan *gostore.Item, 10)
+
+		store.OnItemDidExpire(func(item *gostore.Item) {
+			ch <- item
+		})
+
+		opts := gostore.PutOptions{TTL: 400 * time.Millisecond, IdleTimeout: 300 * time.Millisecond}
+		err := store.PutWithOptions(&gostore.Item{Key: "session", ID: "1", Value: "data"}, opts)
+		Expect(err).To(BeNil())
+		for i := 0; i < 6; i++ {
+			time.Sleep(100 * time.Millisecond)
+			store.Get("session")
+		}
+
+		var item *gostore.Item
+		Eventually(ch, "1s").Should(Receive(&item))
+		Expect(item.ExpireReason()).To(Equal(gostore.ExpiredDeadline))
+	})
+
 })
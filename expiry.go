@@ -0,0 +1,131 @@
+package gostore
+
+import (
+	"time"
+
+	"github.com/google/btree"
+)
+
+// expiryItem is an entry in the expiry index. The id is only set for items
+// that are members of a list.
+type expiryItem struct {
+	at  time.Time
+	key string
+	id  string
+}
+
+// Less orders the entries by deadline and then by key and id
+func (a expiryItem) Less(b btree.Item) bool {
+	e := b.(expiryItem)
+	if !a.at.Equal(e.at) {
+		return a.at.Before(e.at)
+	}
+	if a.key != e.key {
+		return a.key < e.key
+	}
+	return a.id < e.id
+}
+
+// expiryIndex keeps keys ordered by their expiry deadline so only the keys
+// that are due need to be visited
+type expiryIndex struct {
+	tree *btree.BTree
+}
+
+func newExpiryIndex(degree int) *expiryIndex {
+	return &expiryIndex{
+		tree: btree.New(degree),
+	}
+}
+
+func (x *expiryIndex) add(key, id string, at time.Time) {
+	x.tree.ReplaceOrInsert(expiryItem{at: at, key: key, id: id})
+}
+
+func (x *expiryIndex) remove(key, id string, at time.Time) {
+	x.tree.Delete(expiryItem{at: at, key: key, id: id})
+}
+
+// next returns the earliest deadline in the index
+func (x *expiryIndex) next() (time.Time, bool) {
+	min := x.tree.Min()
+	if min == nil {
+		return time.Time{}, false
+	}
+	return min.(expiryItem).at, true
+}
+
+// due removes and returns the entries with a deadline at or before now
+func (x *expiryIndex) due(now time.Time) []expiryItem {
+	var items []expiryItem
+	for {
+		min := x.tree.Min()
+		if min == nil || min.(expiryItem).at.After(now) {
+			return items
+		}
+		x.tree.DeleteMin()
+		items = append(items, min.(expiryItem))
+	}
+}
+
+func (x *expiryIndex) len() int {
+	return x.tree.Len()
+}
+
+// expiryTimer wakes an event loop at the next deadline of an expiry index
+type expiryTimer struct {
+	timer  *time.Timer
+	wakeAt time.Time
+	tick   time.Duration // the longest the timer waits, 0 for no limit
+	clock  Clock
+}
+
+func newExpiryTimer(opts *settings) *expiryTimer {
+	t := time.NewTimer(time.Hour)
+	t.Stop()
+	return &expiryTimer{
+		timer: t,
+		tick:  opts.tick,
+		clock: opts.clock,
+	}
+}
+
+// C returns the channel on which the timer fires
+func (t *expiryTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+// fired must be called after receiving from C
+func (t *expiryTimer) fired() {
+	t.wakeAt = time.Time{}
+}
+
+// schedule arms the timer for the next deadline in x if it is not already
+// armed for it, or for the next tick if that comes first
+func (t *expiryTimer) schedule(x *expiryIndex) {
+	next, ok := x.next()
+	now := t.clock.Now()
+	if t.tick > 0 && (!ok || next.Sub(now) > t.tick) {
+		if !t.wakeAt.IsZero() {
+			// the timer is armed for a tick or an earlier deadline
+			return
+		}
+		next, ok = now.Add(t.tick), true
+	}
+	if !ok || next.Equal(t.wakeAt) {
+		return
+	}
+	if !t.wakeAt.IsZero() {
+		t.timer.Stop()
+		select {
+		case <-t.timer.C:
+		default:
+		}
+	}
+	t.wakeAt = next
+	t.timer.Reset(next.Sub(now))
+}
+
+func (t *expiryTimer) stop() {
+	t.timer.Stop()
+}
@@ -2,134 +2,1801 @@
 package gostore
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"os"
+	"sync"
 	"time"
 )
 
 // Store is the interface to the in-memory store
 type Store interface {
 
-	// Init initializes the store
+	// Init initializes the store. If it fails, the later calls return the
+	// reason. Calling it again, or on a store returned by New, does nothing.
 	Init()
 
-	// Close stops all internal goroutines
+	// Close stops all internal goroutines. Calling it again does nothing.
 	Close()
 
 	// Put saves the item in the store given an optional expiry duration.
 	Put(item *Item, d time.Duration) error
 
+	// PutWithOptions saves the item in the store with the given expiry options
+	PutWithOptions(item *Item, opts PutOptions) error
+
+	// PutCtx is like Put and returns ctx.Err() if ctx is done before the
+	// store replies. The item is not saved if ctx is done before the store
+	// gets to the request, but may have been saved if it is done later.
+	PutCtx(ctx context.Context, item *Item, d time.Duration) error
+
+	// PutWithOptionsCtx is like PutWithOptions and honours ctx like PutCtx
+	PutWithOptionsCtx(ctx context.Context, item *Item, opts PutOptions) error
+
+	// PutIfAbsent saves the item only if there is no item for its key. It
+	// returns a *ConflictError if the key exists.
+	PutIfAbsent(item *Item, d time.Duration) error
+
+	// PutIfMatch saves the item only if the ID of the current item for its key
+	// is expectedID. It returns a *ConflictError otherwise.
+	PutIfMatch(item *Item, expectedID string, d time.Duration) error
+
+	// PutIfAbsentCtx is like PutIfAbsent and honours ctx like PutCtx
+	PutIfAbsentCtx(ctx context.Context, item *Item, d time.Duration) error
+
+	// PutIfMatchCtx is like PutIfMatch and honours ctx like PutCtx
+	PutIfMatchCtx(ctx context.Context, item *Item, expectedID string, d time.Duration) error
+
 	// Get returns the item given the key
 	Get(key string) (item *Item, found bool, err error)
 
+	// GetCtx is like Get and returns ctx.Err() if ctx is done before the
+	// store replies
+	GetCtx(ctx context.Context, key string) (item *Item, found bool, err error)
+
 	// Del deletes the item for the key
 	Del(key string) error
 
+	// DelCtx is like Del and honours ctx like PutCtx
+	DelCtx(ctx context.Context, key string) error
+
+	// DelIfMatch deletes the item for the key only if its ID is expectedID. It
+	// returns a *ConflictError otherwise.
+	DelIfMatch(key string, expectedID string) error
+
+	// DelIfMatchCtx is like DelIfMatch and honours ctx like PutCtx
+	DelIfMatchCtx(ctx context.Context, key string, expectedID string) error
+
+	// Scan returns a page of the items selected by the options and the cursor
+	// of the next page, which is empty once the scan is done. Every page is
+	// read separately so other operations can run between pages.
+	Scan(opts ScanOptions) (items []*Item, next string, err error)
+
+	// ScanCtx is like Scan and honours ctx like GetCtx
+	ScanCtx(ctx context.Context, opts ScanOptions) (items []*Item, next string, err error)
+
+	// Range returns the items with keys from start, inclusive, to end,
+	// exclusive, in order. The range is unbounded if end is empty.
+	Range(start, end string) ([]*Item, error)
+
+	// RangeCtx is like Range and honours ctx like GetCtx
+	RangeCtx(ctx context.Context, start, end string) ([]*Item, error)
+
+	// Keys returns the keys matching the glob pattern, using the syntax of
+	// path.Match, in order
+	Keys(pattern string) ([]string, error)
+
+	// KeysCtx is like Keys and honours ctx like GetCtx
+	KeysCtx(ctx context.Context, pattern string) ([]string, error)
+
+	// Count returns the number of keys starting with prefix
+	Count(prefix string) (int, error)
+
+	// CountCtx is like Count and honours ctx like GetCtx
+	CountCtx(ctx context.Context, prefix string) (int, error)
+
+	// TTL returns the time left before the item for the key expires, or
+	// NoExpiry if the item does not expire
+	TTL(key string) (ttl time.Duration, found bool, err error)
+
+	// TTLCtx is like TTL and honours ctx like GetCtx
+	TTLCtx(ctx context.Context, key string) (ttl time.Duration, found bool, err error)
+
+	// Expire sets the item for the key to expire after the duration d
+	Expire(key string, d time.Duration) (found bool, err error)
+
+	// ExpireCtx is like Expire and honours ctx like PutCtx
+	ExpireCtx(ctx context.Context, key string, d time.Duration) (found bool, err error)
+
+	// ExpireAt sets the item for the key to expire at the time t
+	ExpireAt(key string, t time.Time) (found bool, err error)
+
+	// ExpireAtCtx is like ExpireAt and honours ctx like PutCtx
+	ExpireAtCtx(ctx context.Context, key string, t time.Time) (found bool, err error)
+
+	// Persist removes the expiry of the item for the key
+	Persist(key string) (found bool, err error)
+
+	// PersistCtx is like Persist and honours ctx like PutCtx
+	PersistCtx(ctx context.Context, key string) (found bool, err error)
+
+	// Touch extends the expiry of the item for the key by the duration it was
+	// last given to live with Put or Expire and restarts its idle timeout
+	Touch(key string) (found bool, err error)
+
+	// TouchCtx is like Touch and honours ctx like PutCtx
+	TouchCtx(ctx context.Context, key string) (found bool, err error)
+
+	// Incr adds 1 to the counter for the key and returns the new value
+	Incr(key string) (int64, error)
+
+	// IncrCtx is like Incr and honours ctx like PutCtx
+	IncrCtx(ctx context.Context, key string) (int64, error)
+
+	// IncrBy adds delta to the counter for the key and returns the new value.
+	// A missing key is created with the value 0 first. The counter is a
+	// key/value item holding an int64, with an empty ID. A *NotNumericError is
+	// returned if the item holds a value that is not an integer.
+	IncrBy(key string, delta int64) (int64, error)
+
+	// IncrByCtx is like IncrBy and honours ctx like PutCtx
+	IncrByCtx(ctx context.Context, key string, delta int64) (int64, error)
+
+	// IncrByWithTTL is like IncrBy. The counter expires after the duration d
+	// if it is created by the call; the expiry of an existing counter is left
+	// unchanged, which makes it suitable for fixed rate limiting windows.
+	IncrByWithTTL(key string, delta int64, d time.Duration) (int64, error)
+
+	// IncrByWithTTLCtx is like IncrByWithTTL and honours ctx like PutCtx
+	IncrByWithTTLCtx(ctx context.Context, key string, delta int64, d time.Duration) (int64, error)
+
+	// Decr subtracts 1 from the counter for the key and returns the new value
+	Decr(key string) (int64, error)
+
+	// DecrCtx is like Decr and honours ctx like PutCtx
+	DecrCtx(ctx context.Context, key string) (int64, error)
+
+	// IncrByFloat adds delta to the number held by the item for the key and
+	// returns the new value. The item holds a float64 afterwards. Integer
+	// values are converted.
+	IncrByFloat(key string, delta float64) (float64, error)
+
+	// IncrByFloatCtx is like IncrByFloat and honours ctx like PutCtx
+	IncrByFloatCtx(ctx context.Context, key string, delta float64) (float64, error)
+
 	// ListPush adds the item to the list of items
 	ListPush(key string, value *Item) error
 
+	// ListPushCtx is like ListPush and honours ctx like PutCtx
+	ListPushCtx(ctx context.Context, key string, value *Item) error
+
+	// ListPushWithTTL adds the item to the list of items. The item is removed
+	// from the list once the duration d elapses.
+	ListPushWithTTL(key string, value *Item, d time.Duration) error
+
+	// ListPushWithTTLCtx is like ListPushWithTTL and honours ctx like PutCtx
+	ListPushWithTTLCtx(ctx context.Context, key string, value *Item, d time.Duration) error
+
 	// ListGet returns the list of items given a key
 	ListGet(key string) (items []*Item, found bool, err error)
 
+	// ListGetCtx is like ListGet and honours ctx like GetCtx
+	ListGetCtx(ctx context.Context, key string) (items []*Item, found bool, err error)
+
 	// ListDel deletes the item from the list
 	ListDel(key string, value *Item) error
 
+	// ListDelCtx is like ListDel and honours ctx like PutCtx
+	ListDelCtx(ctx context.Context, key string, value *Item) error
+
+	// ListRange returns up to limit members of the list in order of their ID,
+	// starting at the member with the ID fromID or the first member if fromID
+	// is empty. next is the ID to pass as fromID for the following page and is
+	// empty once the end of the list is reached.
+	ListRange(key string, fromID string, limit int) (items []*Item, next string, err error)
+
+	// ListRangeCtx is like ListRange and honours ctx like GetCtx
+	ListRangeCtx(ctx context.Context, key string, fromID string, limit int) (items []*Item, next string, err error)
+
+	// ListRangeReverse is like ListRange in the reverse order. An empty fromID
+	// starts at the last member.
+	ListRangeReverse(key string, fromID string, limit int) (items []*Item, next string, err error)
+
+	// ListRangeReverseCtx is like ListRangeReverse and honours ctx like GetCtx
+	ListRangeReverseCtx(ctx context.Context, key string, fromID string, limit int) (items []*Item, next string, err error)
+
+	// ListLen returns the number of members in the list
+	ListLen(key string) (int, error)
+
+	// ListLenCtx is like ListLen and honours ctx like GetCtx
+	ListLenCtx(ctx context.Context, key string) (int, error)
+
+	// ListContains returns true if the list has a member with the ID
+	ListContains(key string, id string) (bool, error)
+
+	// ListContainsCtx is like ListContains and honours ctx like GetCtx
+	ListContainsCtx(ctx context.Context, key string, id string) (bool, error)
+
+	// ListGetItem returns the member of the list with the ID
+	ListGetItem(key string, id string) (item *Item, found bool, err error)
+
+	// ListGetItemCtx is like ListGetItem and honours ctx like GetCtx
+	ListGetItemCtx(ctx context.Context, key string, id string) (item *Item, found bool, err error)
+
+	// Apply applies the operations of the batch atomically. Every operation is
+	// validated first and none is applied if one is invalid. No other change
+	// to the store is made while the batch is applied and the changes are
+	// reported to Watch as a single EventBatch.
+	Apply(b *Batch) error
+
+	// ApplyCtx is like Apply and honours ctx like PutCtx. Nothing is applied
+	// if ctx is done before the store is paused for the batch.
+	ApplyCtx(ctx context.Context, b *Batch) error
+
+	// Txn runs fn in an optimistic transaction. The operations queued in the
+	// transaction are applied atomically only if none of the keys and lists
+	// watched by fn changed since they were watched. On a conflict fn is run
+	// again, up to the configured number of retries, after which
+	// ErrTxnConflict is returned. Nothing is applied if fn returns an error.
+	Txn(fn func(tx *Txn) error) error
+
+	// TxnCtx is like Txn and honours ctx like PutCtx. Watch and WatchList
+	// return ctx.Err() too, and fn is not run again once ctx is done.
+	TxnCtx(ctx context.Context, fn func(tx *Txn) error) error
+
+	// LPush adds the values to the head of the insertion ordered list for the
+	// key, so the last value becomes the first member, and returns the length
+	// of the list. Insertion ordered lists allow duplicates and are separate
+	// from the lists of ListPush, which are ordered by Item.ID.
+	LPush(key string, values ...*Item) (length int, err error)
+
+	// LPushCtx is like LPush and honours ctx like PutCtx
+	LPushCtx(ctx context.Context, key string, values ...*Item) (length int, err error)
+
+	// RPush adds the values to the tail of the insertion ordered list for the
+	// key and returns the length of the list
+	RPush(key string, values ...*Item) (length int, err error)
+
+	// RPushCtx is like RPush and honours ctx like PutCtx
+	RPushCtx(ctx context.Context, key string, values ...*Item) (length int, err error)
+
+	// LPop removes and returns the first member of the insertion ordered list
+	LPop(key string) (item *Item, found bool, err error)
+
+	// LPopCtx is like LPop and honours ctx like PutCtx
+	LPopCtx(ctx context.Context, key string) (item *Item, found bool, err error)
+
+	// RPop removes and returns the last member of the insertion ordered list
+	RPop(key string) (item *Item, found bool, err error)
+
+	// RPopCtx is like RPop and honours ctx like PutCtx
+	RPopCtx(ctx context.Context, key string) (item *Item, found bool, err error)
+
+	// LIndex returns the member at the index of the insertion ordered list.
+	// Negative indexes count from the end of the list.
+	LIndex(key string, index int) (item *Item, found bool, err error)
+
+	// LIndexCtx is like LIndex and honours ctx like GetCtx
+	LIndexCtx(ctx context.Context, key string, index int) (item *Item, found bool, err error)
+
+	// LRange returns the members of the insertion ordered list from start to
+	// stop, inclusive. Negative indexes count from the end of the list.
+	LRange(key string, start, stop int) ([]*Item, error)
+
+	// LRangeCtx is like LRange and honours ctx like GetCtx
+	LRangeCtx(ctx context.Context, key string, start, stop int) ([]*Item, error)
+
+	// LTrim removes the members of the insertion ordered list outside of
+	// start to stop, inclusive. Negative indexes count from the end of the list.
+	LTrim(key string, start, stop int) error
+
+	// LTrimCtx is like LTrim and honours ctx like PutCtx
+	LTrimCtx(ctx context.Context, key string, start, stop int) error
+
+	// LInsert inserts the value before or after the first member of the
+	// insertion ordered list with the ID pivotID and returns the length of
+	// the list, or -1 if there is no such member
+	LInsert(key string, pos InsertPosition, pivotID string, value *Item) (length int, err error)
+
+	// LInsertCtx is like LInsert and honours ctx like PutCtx
+	LInsertCtx(ctx context.Context, key string, pos InsertPosition, pivotID string, value *Item) (length int, err error)
+
+	// LLen returns the length of the insertion ordered list
+	LLen(key string) (int, error)
+
+	// LLenCtx is like LLen and honours ctx like GetCtx
+	LLenCtx(ctx context.Context, key string) (int, error)
+
+	// ZAdd adds the member with the ID to the sorted set for the key, or
+	// updates its score and value, and returns true if the member is new.
+	// Sorted sets are ordered by score and then by ID.
+	ZAdd(key string, id string, score float64, value interface{}) (added bool, err error)
+
+	// ZAddCtx is like ZAdd and honours ctx like PutCtx
+	ZAddCtx(ctx context.Context, key string, id string, score float64, value interface{}) (added bool, err error)
+
+	// ZIncrBy adds delta to the score of the member with the ID, adding the
+	// member with a nil value if it does not exist, and returns the new score
+	ZIncrBy(key string, id string, delta float64) (score float64, err error)
+
+	// ZIncrByCtx is like ZIncrBy and honours ctx like PutCtx
+	ZIncrByCtx(ctx context.Context, key string, id string, delta float64) (score float64, err error)
+
+	// ZRangeByScore returns the members of the sorted set with a score from
+	// min to max, inclusive, in order
+	ZRangeByScore(key string, min, max float64) ([]ZMember, error)
+
+	// ZRangeByScoreCtx is like ZRangeByScore and honours ctx like GetCtx
+	ZRangeByScoreCtx(ctx context.Context, key string, min, max float64) ([]ZMember, error)
+
+	// ZRank returns the index of the member with the ID in the sorted set
+	ZRank(key string, id string) (rank int, found bool, err error)
+
+	// ZRankCtx is like ZRank and honours ctx like GetCtx
+	ZRankCtx(ctx context.Context, key string, id string) (rank int, found bool, err error)
+
+	// ZRem removes the members with the IDs from the sorted set and returns
+	// the number of members removed
+	ZRem(key string, ids ...string) (removed int, err error)
+
+	// ZRemCtx is like ZRem and honours ctx like PutCtx
+	ZRemCtx(ctx context.Context, key string, ids ...string) (removed int, err error)
+
+	// ZPopMin removes and returns the member of the sorted set with the
+	// lowest score
+	ZPopMin(key string) (member ZMember, found bool, err error)
+
+	// ZPopMinCtx is like ZPopMin and honours ctx like PutCtx
+	ZPopMinCtx(ctx context.Context, key string) (member ZMember, found bool, err error)
+
+	// ZPopMax removes and returns the member of the sorted set with the
+	// highest score
+	ZPopMax(key string) (member ZMember, found bool, err error)
+
+	// ZPopMaxCtx is like ZPopMax and honours ctx like PutCtx
+	ZPopMaxCtx(ctx context.Context, key string) (member ZMember, found bool, err error)
+
+	// OnZSetDidChange adds a callback called with the contents of a sorted
+	// set after every change. The returned function removes the callback.
+	OnZSetDidChange(func(key string, members []ZMember)) (cancel func())
+
+	// HSet sets the field of the hash for the key to value and returns true if
+	// the field is new. A hash is a key/value item whose fields are changed
+	// individually, so its expiry is set with Expire, ExpireAt and Persist.
+	// Get returns ErrWrongKind for a hash.
+	HSet(key string, field string, value interface{}) (created bool, err error)
+
+	// HSetCtx is like HSet and honours ctx like PutCtx
+	HSetCtx(ctx context.Context, key string, field string, value interface{}) (created bool, err error)
+
+	// HGet returns the value of the field of the hash
+	HGet(key string, field string) (value interface{}, found bool, err error)
+
+	// HGetCtx is like HGet and honours ctx like GetCtx
+	HGetCtx(ctx context.Context, key string, field string) (value interface{}, found bool, err error)
+
+	// HDel removes the fields from the hash and returns the number of fields
+	// removed. The hash is deleted once its last field is removed.
+	HDel(key string, fields ...string) (removed int, err error)
+
+	// HDelCtx is like HDel and honours ctx like PutCtx
+	HDelCtx(ctx context.Context, key string, fields ...string) (removed int, err error)
+
+	// HGetAll returns a copy of the fields of the hash
+	HGetAll(key string) (map[string]interface{}, error)
+
+	// HGetAllCtx is like HGetAll and honours ctx like GetCtx
+	HGetAllCtx(ctx context.Context, key string) (map[string]interface{}, error)
+
+	// HIncrBy adds delta to the integer value of the field, which is created
+	// with the value 0 if it does not exist, and returns the new value. The
+	// field holds an int64 afterwards.
+	HIncrBy(key string, field string, delta int64) (int64, error)
+
+	// HIncrByCtx is like HIncrBy and honours ctx like PutCtx
+	HIncrByCtx(ctx context.Context, key string, field string, delta int64) (int64, error)
+
+	// HLen returns the number of fields in the hash
+	HLen(key string) (int, error)
+
+	// HLenCtx is like HLen and honours ctx like GetCtx
+	HLenCtx(ctx context.Context, key string) (int, error)
+
+	// HKeys returns the fields of the hash in lexicographic order
+	HKeys(key string) ([]string, error)
+
+	// HKeysCtx is like HKeys and honours ctx like GetCtx
+	HKeysCtx(ctx context.Context, key string) ([]string, error)
+
+	// SAdd adds the members to the set for the key and returns the number of
+	// members that were not in the set. A set is a key/value item holding
+	// unique strings, so its expiry is set with Expire, ExpireAt and Persist.
+	// Get returns ErrWrongKind for a set.
+	SAdd(key string, members ...string) (added int, err error)
+
+	// SAddCtx is like SAdd and honours ctx like PutCtx
+	SAddCtx(ctx context.Context, key string, members ...string) (added int, err error)
+
+	// SRem removes the members from the set and returns the number of members
+	// removed. The set is deleted once its last member is removed.
+	SRem(key string, members ...string) (removed int, err error)
+
+	// SRemCtx is like SRem and honours ctx like PutCtx
+	SRemCtx(ctx context.Context, key string, members ...string) (removed int, err error)
+
+	// SIsMember returns true if the member is in the set
+	SIsMember(key string, member string) (bool, error)
+
+	// SIsMemberCtx is like SIsMember and honours ctx like GetCtx
+	SIsMemberCtx(ctx context.Context, key string, member string) (bool, error)
+
+	// SCard returns the number of members in the set
+	SCard(key string) (int, error)
+
+	// SCardCtx is like SCard and honours ctx like GetCtx
+	SCardCtx(ctx context.Context, key string) (int, error)
+
+	// SMembers returns the members of the set in lexicographic order
+	SMembers(key string) ([]string, error)
+
+	// SMembersCtx is like SMembers and honours ctx like GetCtx
+	SMembersCtx(ctx context.Context, key string) ([]string, error)
+
+	// SInter returns the members found in every set for the keys, in
+	// lexicographic order. Missing keys are empty sets.
+	SInter(keys ...string) ([]string, error)
+
+	// SInterCtx is like SInter and honours ctx like GetCtx
+	SInterCtx(ctx context.Context, keys ...string) ([]string, error)
+
+	// SUnion returns the members found in any of the sets for the keys
+	SUnion(keys ...string) ([]string, error)
+
+	// SUnionCtx is like SUnion and honours ctx like GetCtx
+	SUnionCtx(ctx context.Context, keys ...string) ([]string, error)
+
+	// SDiff returns the members of the set for the first key that are not in
+	// the sets for the other keys
+	SDiff(keys ...string) ([]string, error)
+
+	// SDiffCtx is like SDiff and honours ctx like GetCtx
+	SDiffCtx(ctx context.Context, keys ...string) ([]string, error)
+
+	// SInterStore is like SInter and replaces the item for dest with the
+	// result, or deletes it if the result is empty. It returns the number of
+	// members of the result.
+	SInterStore(dest string, keys ...string) (int, error)
+
+	// SInterStoreCtx is like SInterStore and honours ctx like PutCtx
+	SInterStoreCtx(ctx context.Context, dest string, keys ...string) (int, error)
+
+	// SUnionStore is like SUnion and saves the result under dest
+	SUnionStore(dest string, keys ...string) (int, error)
+
+	// SUnionStoreCtx is like SUnionStore and honours ctx like PutCtx
+	SUnionStoreCtx(ctx context.Context, dest string, keys ...string) (int, error)
+
+	// SDiffStore is like SDiff and saves the result under dest
+	SDiffStore(dest string, keys ...string) (int, error)
+
+	// SDiffStoreCtx is like SDiffStore and honours ctx like PutCtx
+	SDiffStoreCtx(ctx context.Context, dest string, keys ...string) (int, error)
+
+	// BlockingPop removes and returns the first member of the first of the
+	// lists for the keys that is not empty, waiting until a member is pushed
+	// to one of them or ctx is done. Every member is handed to a single
+	// caller and callers waiting on the same list are served in the order
+	// they started waiting. It works on lists of both kinds; the first member
+	// of a list ordered by ID is the one with the lowest ID.
+	BlockingPop(ctx context.Context, keys []string) (key string, item *Item, err error)
+
 	// OnItemDidExpire adds the callback function to the list off callback functions
-	// called when an item expires
-	OnItemDidExpire(func(item *Item))
+	// called when an item expires. The returned function removes the callback.
+	OnItemDidExpire(func(item *Item)) (cancel func())
 
-	// OnListDidChange adds a callback to change in list
-	OnListDidChange(func(key string, items []*Item))
+	// OnItemEvicted adds the callback function called when an item is evicted
+	// to keep the store within its MaxItems or MaxBytes limit. Expired items
+	// are reported to OnItemDidExpire instead. The returned function removes
+	// the callback.
+	OnItemEvicted(func(item *Item)) (cancel func())
+
+	// OnListDidChange adds a callback to change in list. The returned function
+	// removes the callback.
+	OnListDidChange(func(key string, items []*Item)) (cancel func())
+
+	// OnListDiff adds a callback called with the members added to and removed
+	// from a list by each change. The returned function removes the callback.
+	OnListDiff(func(diff ListDiff)) (cancel func())
+
+	// OnListItemDidExpire adds the callback function called when an item
+	// pushed with ListPushWithTTL expires and is removed from its list. The
+	// returned function removes the callback.
+	OnListItemDidExpire(func(key string, item *Item)) (cancel func())
+
+	// Snapshot writes all items and lists, including their expiry deadlines, to w.
+	// Values are encoded with encoding/gob so custom Value types must be
+	// registered with gob.Register.
+	Snapshot(w io.Writer) error
+
+	// Restore replaces the contents of the store with a snapshot read from r.
+	// Items that have expired since the snapshot was taken are skipped.
+	Restore(r io.Reader) error
+
+	// SnapshotFile writes a snapshot to the file at path
+	SnapshotFile(path string) error
+
+	// RestoreFile restores the store from the snapshot file at path
+	RestoreFile(path string) error
+
+	// Watch returns a channel that receives an Event for every change to the
+	// store matching the filter. The channel is closed once ctx is done or the
+	// store is closed. Events are queued using the Delivery configuration.
+	Watch(ctx context.Context, filter EventFilter) <-chan Event
+
+	// WatchWithOptions is like Watch with its own delivery options
+	WatchWithOptions(ctx context.Context, filter EventFilter, opts DeliveryOptions) <-chan Event
+
+	// DroppedEvents returns the number of events dropped because the queue of
+	// a callback or watcher was full
+	DroppedEvents() uint64
+
+	// MemoryUsage returns the approximate memory in bytes used by the
+	// key/value item and the list for the key
+	MemoryUsage(key string) (bytes int64, found bool, err error)
+
+	// MemoryUsageCtx is like MemoryUsage and honours ctx like GetCtx
+	MemoryUsageCtx(ctx context.Context, key string) (bytes int64, found bool, err error)
+
+	// Stats returns the number of items and lists and the approximate memory
+	// they use
+	Stats() (Stats, error)
+
+	// StatsCtx is like Stats and honours ctx like GetCtx
+	StatsCtx(ctx context.Context) (Stats, error)
+
+	// CompactLog rewrites the append-only log with the minimal set of records
+	// needed to recreate the current contents of the store
+	CompactLog() error
+}
+
+// PutOptions are the expiry options of an item saved with PutWithOptions
+type PutOptions struct {
+	// TTL is the duration after which the item expires. The item has no
+	// absolute expiry if TTL is 0.
+	TTL time.Duration
+
+	// IdleTimeout is the duration after which the item expires if it is not
+	// read with Get. Every Get restarts the timeout. The item has no idle
+	// expiry if IdleTimeout is 0.
+	IdleTimeout time.Duration
+}
+
+// ConflictError is returned when the condition of PutIfAbsent, PutIfMatch or
+// DelIfMatch is not met
+type ConflictError struct {
+	Key      string
+	Expected string // the expected ID, empty for PutIfAbsent
+	Actual   string // the ID of the current item, empty if there is none
+}
+
+func (e *ConflictError) Error() string {
+	if len(e.Expected) == 0 {
+		return fmt.Sprintf("conflict: key \"%s\" exists with ID \"%s\"", e.Key, e.Actual)
+	}
+	if len(e.Actual) == 0 {
+		return fmt.Sprintf("conflict: key \"%s\" not found, expected ID \"%s\"", e.Key, e.Expected)
+	}
+	return fmt.Sprintf("conflict: key \"%s\" has ID \"%s\", expected \"%s\"", e.Key, e.Actual, e.Expected)
+}
+
+// ErrWrongKind is returned by an operation on a key that holds a different
+// kind of value, such as ListPush on an insertion ordered list
+var ErrWrongKind = errors.New("operation against a key holding the wrong kind of value")
+
+// IsConflict returns true if err is a *ConflictError
+func IsConflict(err error) bool {
+	_, ok := err.(*ConflictError)
+	return ok
+}
+
+// NotNumericError is returned when a counter or a hash field incremented by
+// HIncrBy holds a value that is not a number
+type NotNumericError struct {
+	Key   string
+	Field string // the hash field, empty for a counter
+	Value interface{}
+
+	integer bool // an integer was expected
+}
+
+func (e *NotNumericError) Error() string {
+	expected := "a number"
+	if e.integer {
+		expected = "an integer"
+	}
+	if len(e.Field) == 0 {
+		return fmt.Sprintf("value of key \"%s\" is %T, not %s", e.Key, e.Value, expected)
+	}
+	return fmt.Sprintf("field \"%s\" of key \"%s\" is %T, not %s", e.Field, e.Key, e.Value, expected)
+}
+
+// IsNotNumeric returns true if err is a *NotNumericError
+func IsNotNumeric(err error) bool {
+	_, ok := err.(*NotNumericError)
+	return ok
+}
+
+// NoExpiry is the TTL reported for items that do not expire
+const NoExpiry time.Duration = -1
+
+// Config is the configuration of a store
+type Config struct {
+	// LogPath is the path of the append-only log. Every Put, Del, ListPush
+	// and ListDel is recorded in the log and the log is replayed on Init.
+	// Logging is disabled if LogPath is empty.
+	LogPath string
+
+	// LogSync controls how often the log is flushed to disk
+	LogSync SyncPolicy
+
+	// LogCompactSize is the size in bytes past which the log is compacted in
+	// the background whenever it has doubled since the last compaction.
+	// Automatic compaction is disabled if LogCompactSize is 0.
+	LogCompactSize int64
+
+	// Delivery controls how events are queued for the callbacks and for Watch
+	Delivery DeliveryOptions
+
+	// TxnRetries is the number of times Txn runs its function again after a
+	// conflict. DefaultTxnRetries is used if TxnRetries is 0.
+	TxnRetries int
+
+	// MaxItems is the maximum number of key/value items, including hashes,
+	// sets and counters. Lists are not counted. There is no limit if
+	// MaxItems is 0.
+	MaxItems int
+
+	// MaxBytes is the approximate maximum memory used by the key/value items.
+	// There is no limit if MaxBytes is 0.
+	MaxBytes int64
+
+	// Eviction chooses the items evicted once MaxItems or MaxBytes is
+	// reached. The default is NoEviction.
+	Eviction EvictionPolicy
+
+	// SizeEstimator estimates the memory used by the values that do not
+	// implement Sizer. DefaultSizeEstimator is used if SizeEstimator is nil.
+	SizeEstimator SizeEstimator
 }
 
 // NewStore returns a new instance of Store
 func NewStore() Store {
-	s := &store{}
+	return NewStoreWithConfig(Config{})
+}
+
+// NewStoreWithConfig returns a new instance of Store using the given configuration
+func NewStoreWithConfig(cfg Config) Store {
+	s := &store{
+		cfg:  cfg,
+		opts: defaultSettings(),
+	}
 	return s
 }
 
 // Store implements a key/value in-memory storage
 type store struct {
-	ls *listStore
-	kv *kvStore
+	cfg    Config
+	opts   *settings
+	ls     *listStore
+	kv     *kvStore
+	wlog   *appendLog
+	events *eventHub
+
+	initErr   error // why Init failed, returned by the later calls
+	initOnce  sync.Once
+	closeOnce sync.Once
 }
 
 func (s *store) Init() {
-	s.ls = newListStore()
-	s.ls.init()
-	s.kv = newKVStore()
-	s.kv.init()
+	if err := s.start(); err != nil {
+		s.opts.logf("ERROR: Init failed: %v", err)
+	}
 }
 
-func (s *store) Close() {
-	if s.ls != nil {
-		s.ls.closeStore()
+// start initializes the store the first time it is called and returns the
+// result of that call afterwards
+func (s *store) start() error {
+	s.initOnce.Do(func() {
+		s.initErr = s.init()
+	})
+	return s.initErr
+}
+
+// errNotReady logs and returns the error of a call made before Init
+// succeeded
+func (s *store) errNotReady() error {
+	err := fmt.Errorf("ERROR: Init must be called first")
+	if s.initErr != nil {
+		err = fmt.Errorf("ERROR: Init failed: %v", s.initErr)
 	}
-	if s.kv != nil {
-		s.kv.closeStore()
+	s.opts.logf("%v", err)
+	return err
+}
+
+func (s *store) init() error {
+	ls := newListStore(s.opts)
+	kv := newKVStore(s.opts)
+	kv.evict = newEvictor(s.cfg, s.opts.degree)
+	kv.estimate = s.cfg.SizeEstimator
+	ls.estimate = s.cfg.SizeEstimator
+	if len(s.cfg.LogPath) > 0 {
+		st := newStoreState()
+		l, err := openAppendLog(s.cfg.LogPath, s.cfg.LogSync, s.cfg.LogCompactSize, st, s.opts.logger)
+		if err != nil {
+			return err
+		}
+		items, lc := st.contents(s.opts.now())
+		kv.replaceItems(items)
+		ls.replaceLists(lc)
+		l.dump = s.dumpRecords
+		kv.wlog = l
+		ls.wlog = l
+		s.wlog = l
 	}
+	s.events = newEventHub(s.opts.clock, s.opts.sendTimeout)
+	kv.events = s.events
+	ls.events = s.events
+	ls.init()
+	kv.init()
+	s.ls = ls
+	s.kv = kv
+	return nil
+}
+
+func (s *store) Close() {
+	s.closeOnce.Do(func() {
+		if s.ls != nil {
+			s.ls.closeStore()
+		}
+		if s.kv != nil {
+			s.kv.closeStore()
+		}
+		s.events.closeHub()
+		if err := s.wlog.closeLog(); err != nil {
+			s.opts.logf("ERROR: closing log: %v", err)
+		}
+	})
 }
 
 func (s *store) Put(item *Item, d time.Duration) error {
+	return s.PutCtx(context.Background(), item, d)
+}
+
+func (s *store) PutWithOptions(item *Item, opts PutOptions) error {
+	return s.PutWithOptionsCtx(context.Background(), item, opts)
+}
+
+func (s *store) PutCtx(ctx context.Context, item *Item, d time.Duration) error {
+	return s.PutWithOptionsCtx(ctx, item, PutOptions{TTL: d})
+}
+
+func (s *store) PutWithOptionsCtx(ctx context.Context, item *Item, opts PutOptions) error {
+	if s.kv == nil {
+		return s.errNotReady()
+	}
+	return s.kv.put(ctx, item, opts)
+}
+
+func (s *store) PutIfAbsent(item *Item, d time.Duration) error {
+	return s.PutIfAbsentCtx(context.Background(), item, d)
+}
+
+func (s *store) PutIfAbsentCtx(ctx context.Context, item *Item, d time.Duration) error {
 	if s.kv == nil {
-		log.Printf("ERROR: Init must be called first")
-		return fmt.Errorf("ERROR: Init must be called first")
+		return s.errNotReady()
 	}
-	return s.kv.put(item, d)
+	return s.kv.putIf(ctx, item, PutOptions{TTL: d}, condAbsent, "")
+}
+
+func (s *store) PutIfMatch(item *Item, expectedID string, d time.Duration) error {
+	return s.PutIfMatchCtx(context.Background(), item, expectedID, d)
+}
+
+func (s *store) PutIfMatchCtx(ctx context.Context, item *Item, expectedID string, d time.Duration) error {
+	if s.kv == nil {
+		return s.errNotReady()
+	}
+	return s.kv.putIf(ctx, item, PutOptions{TTL: d}, condMatch, expectedID)
 }
 
 func (s *store) Get(key string) (item *Item, found bool, err error) {
+	return s.GetCtx(context.Background(), key)
+}
+
+func (s *store) GetCtx(ctx context.Context, key string) (item *Item, found bool, err error) {
 	if s.kv == nil {
-		log.Printf("ERROR: Init must be called first")
-		return nil, false, fmt.Errorf("ERROR: Init must be called first")
+		return nil, false, s.errNotReady()
 	}
-	return s.kv.getItem(key)
+	return s.kv.getItem(ctx, key)
 }
 
 func (s *store) Del(key string) error {
+	return s.DelCtx(context.Background(), key)
+}
+
+func (s *store) DelCtx(ctx context.Context, key string) error {
 	if s.kv == nil {
-		log.Printf("ERROR: Init must be called first")
-		return fmt.Errorf("ERROR: Init must be called first")
+		return s.errNotReady()
 	}
-	return s.kv.delItem(key)
+	return s.kv.delItem(ctx, key)
 }
 
-func (s *store) ListPush(key string, value *Item) error {
-	if s.ls == nil {
-		log.Printf("ERROR: Init must be called first")
-		return fmt.Errorf("ERROR: Init must be called first")
+func (s *store) DelIfMatch(key string, expectedID string) error {
+	return s.DelIfMatchCtx(context.Background(), key, expectedID)
+}
+
+func (s *store) DelIfMatchCtx(ctx context.Context, key string, expectedID string) error {
+	if s.kv == nil {
+		return s.errNotReady()
 	}
-	return s.ls.listPush(key, value)
+	return s.kv.delIf(ctx, key, condMatch, expectedID)
 }
 
-func (s *store) ListDel(key string, value *Item) error {
-	if s.ls == nil {
-		log.Printf("ERROR: Init must be called first")
-		return fmt.Errorf("ERROR: Init must be called first")
+func (s *store) Scan(opts ScanOptions) ([]*Item, string, error) {
+	return s.ScanCtx(context.Background(), opts)
+}
+
+func (s *store) ScanCtx(ctx context.Context, opts ScanOptions) ([]*Item, string, error) {
+	if s.kv == nil {
+		return nil, "", s.errNotReady()
 	}
-	return s.ls.listDel(key, value)
+	r, err := s.kv.scanPage(ctx, opts, false)
+	if err != nil {
+		return nil, "", err
+	}
+	items := make([]*Item, len(r.items))
+	for i := range r.items {
+		items[i] = &r.items[i]
+	}
+	return items, r.next, nil
 }
 
-func (s *store) ListGet(key string) ([]*Item, bool, error) {
-	if s.ls == nil {
-		log.Printf("ERROR: Init must be called first")
-		return nil, false, fmt.Errorf("ERROR: Init must be called first")
+func (s *store) Range(start, end string) ([]*Item, error) {
+	return s.RangeCtx(context.Background(), start, end)
+}
+
+func (s *store) RangeCtx(ctx context.Context, start, end string) ([]*Item, error) {
+	if s.kv == nil {
+		return nil, s.errNotReady()
 	}
-	return s.ls.listGet(key)
+	items := make([]*Item, 0)
+	err := s.kv.scanAll(ctx, ScanOptions{Start: start, End: end}, false, func(r scanResp) {
+		for i := range r.items {
+			items = append(items, &r.items[i])
+		}
+	})
+	return items, err
 }
 
-func (s *store) OnItemDidExpire(cb func(item *Item)) {
-	if s.kv != nil {
-		s.kv.onItemDidExpire(cb)
-	} else {
-		panic(fmt.Errorf("Init not yet called"))
+func (s *store) Keys(pattern string) ([]string, error) {
+	return s.KeysCtx(context.Background(), pattern)
+}
+
+func (s *store) KeysCtx(ctx context.Context, pattern string) ([]string, error) {
+	if s.kv == nil {
+		return nil, s.errNotReady()
 	}
+	keys := make([]string, 0)
+	opts := ScanOptions{Prefix: globPrefix(pattern), Pattern: pattern}
+	err := s.kv.scanAll(ctx, opts, true, func(r scanResp) {
+		keys = append(keys, r.keys...)
+	})
+	return keys, err
 }
 
-func (s *store) OnListDidChange(cb func(string, []*Item)) {
-	if s.ls != nil {
-		s.ls.onListDidChange(cb)
-	} else {
-		panic(fmt.Errorf("Init not yet called"))
+func (s *store) Count(prefix string) (int, error) {
+	return s.CountCtx(context.Background(), prefix)
+}
+
+func (s *store) CountCtx(ctx context.Context, prefix string) (int, error) {
+	if s.kv == nil {
+		return 0, s.errNotReady()
+	}
+	n := 0
+	err := s.kv.scanAll(ctx, ScanOptions{Prefix: prefix}, true, func(r scanResp) {
+		n += len(r.keys)
+	})
+	return n, err
+}
+
+func (s *store) TTL(key string) (time.Duration, bool, error) {
+	return s.TTLCtx(context.Background(), key)
+}
+
+func (s *store) TTLCtx(ctx context.Context, key string) (time.Duration, bool, error) {
+	if s.kv == nil {
+		return 0, false, s.errNotReady()
+	}
+	return s.kv.changeTTL(ctx, ttlGet, key, 0, time.Time{})
+}
+
+func (s *store) Expire(key string, d time.Duration) (bool, error) {
+	return s.ExpireCtx(context.Background(), key, d)
+}
+
+func (s *store) ExpireCtx(ctx context.Context, key string, d time.Duration) (bool, error) {
+	return s.changeTTL(ctx, ttlExpire, key, d, time.Time{})
+}
+
+func (s *store) ExpireAt(key string, t time.Time) (bool, error) {
+	return s.ExpireAtCtx(context.Background(), key, t)
+}
+
+func (s *store) ExpireAtCtx(ctx context.Context, key string, t time.Time) (bool, error) {
+	return s.changeTTL(ctx, ttlExpireAt, key, 0, t)
+}
+
+func (s *store) Persist(key string) (bool, error) {
+	return s.PersistCtx(context.Background(), key)
+}
+
+func (s *store) PersistCtx(ctx context.Context, key string) (bool, error) {
+	return s.changeTTL(ctx, ttlPersist, key, 0, time.Time{})
+}
+
+func (s *store) Touch(key string) (bool, error) {
+	return s.TouchCtx(context.Background(), key)
+}
+
+func (s *store) TouchCtx(ctx context.Context, key string) (bool, error) {
+	return s.changeTTL(ctx, ttlTouch, key, 0, time.Time{})
+}
+
+func (s *store) changeTTL(ctx context.Context, op ttlOp, key string, d time.Duration, t time.Time) (bool, error) {
+	if s.kv == nil {
+		return false, s.errNotReady()
+	}
+	_, found, err := s.kv.changeTTL(ctx, op, key, d, t)
+	return found, err
+}
+
+func (s *store) Incr(key string) (int64, error) {
+	return s.IncrCtx(context.Background(), key)
+}
+
+func (s *store) IncrCtx(ctx context.Context, key string) (int64, error) {
+	return s.IncrByWithTTLCtx(ctx, key, 1, 0)
+}
+
+func (s *store) IncrBy(key string, delta int64) (int64, error) {
+	return s.IncrByCtx(context.Background(), key, delta)
+}
+
+func (s *store) IncrByCtx(ctx context.Context, key string, delta int64) (int64, error) {
+	return s.IncrByWithTTLCtx(ctx, key, delta, 0)
+}
+
+func (s *store) Decr(key string) (int64, error) {
+	return s.DecrCtx(context.Background(), key)
+}
+
+func (s *store) DecrCtx(ctx context.Context, key string) (int64, error) {
+	return s.IncrByWithTTLCtx(ctx, key, -1, 0)
+}
+
+func (s *store) IncrByWithTTL(key string, delta int64, d time.Duration) (int64, error) {
+	return s.IncrByWithTTLCtx(context.Background(), key, delta, d)
+}
+
+func (s *store) IncrByWithTTLCtx(ctx context.Context, key string, delta int64, d time.Duration) (int64, error) {
+	if s.kv == nil {
+		return 0, s.errNotReady()
+	}
+	v, err := s.kv.runIncr(ctx, incrReq{key: key, delta: delta, ttl: d})
+	n, _ := v.(int64)
+	return n, err
+}
+
+func (s *store) IncrByFloat(key string, delta float64) (float64, error) {
+	return s.IncrByFloatCtx(context.Background(), key, delta)
+}
+
+func (s *store) IncrByFloatCtx(ctx context.Context, key string, delta float64) (float64, error) {
+	if s.kv == nil {
+		return 0, s.errNotReady()
+	}
+	v, err := s.kv.runIncr(ctx, incrReq{key: key, deltaFloat: delta, float: true})
+	f, _ := v.(float64)
+	return f, err
+}
+
+func (s *store) ListPush(key string, value *Item) error {
+	return s.ListPushCtx(context.Background(), key, value)
+}
+
+func (s *store) ListPushCtx(ctx context.Context, key string, value *Item) error {
+	if s.ls == nil {
+		return s.errNotReady()
+	}
+	return s.ls.listPush(ctx, key, value, 0)
+}
+
+func (s *store) ListPushWithTTL(key string, value *Item, d time.Duration) error {
+	return s.ListPushWithTTLCtx(context.Background(), key, value, d)
+}
+
+func (s *store) ListPushWithTTLCtx(ctx context.Context, key string, value *Item, d time.Duration) error {
+	if s.ls == nil {
+		return s.errNotReady()
+	}
+	return s.ls.listPush(ctx, key, value, d)
+}
+
+func (s *store) ListDel(key string, value *Item) error {
+	return s.ListDelCtx(context.Background(), key, value)
+}
+
+func (s *store) ListDelCtx(ctx context.Context, key string, value *Item) error {
+	if s.ls == nil {
+		return s.errNotReady()
+	}
+	return s.ls.listDel(ctx, key, value)
+}
+
+func (s *store) ListGet(key string) ([]*Item, bool, error) {
+	return s.ListGetCtx(context.Background(), key)
+}
+
+func (s *store) ListGetCtx(ctx context.Context, key string) ([]*Item, bool, error) {
+	if s.ls == nil {
+		return nil, false, s.errNotReady()
+	}
+	return s.ls.listGet(ctx, key)
+}
+
+func (s *store) Apply(b *Batch) error {
+	return s.ApplyCtx(context.Background(), b)
+}
+
+func (s *store) ApplyCtx(ctx context.Context, b *Batch) error {
+	if s.kv == nil || s.ls == nil {
+		return s.errNotReady()
+	}
+	if b == nil {
+		return fmt.Errorf("ERROR: nil batch")
+	}
+	return s.applyBatch(ctx, b, nil)
+}
+
+func (s *store) Txn(fn func(tx *Txn) error) error {
+	return s.TxnCtx(context.Background(), fn)
+}
+
+func (s *store) TxnCtx(ctx context.Context, fn func(tx *Txn) error) error {
+	if s.kv == nil || s.ls == nil {
+		return s.errNotReady()
+	}
+	return s.runTxn(ctx, fn)
+}
+
+func (s *store) ListRange(key string, fromID string, limit int) ([]*Item, string, error) {
+	return s.ListRangeCtx(context.Background(), key, fromID, limit)
+}
+
+func (s *store) ListRangeCtx(ctx context.Context, key string, fromID string, limit int) ([]*Item, string, error) {
+	if s.ls == nil {
+		return nil, "", s.errNotReady()
+	}
+	return s.ls.listRange(ctx, key, fromID, limit, false)
+}
+
+func (s *store) ListRangeReverse(key string, fromID string, limit int) ([]*Item, string, error) {
+	return s.ListRangeReverseCtx(context.Background(), key, fromID, limit)
+}
+
+func (s *store) ListRangeReverseCtx(ctx context.Context, key string, fromID string, limit int) ([]*Item, string, error) {
+	if s.ls == nil {
+		return nil, "", s.errNotReady()
+	}
+	return s.ls.listRange(ctx, key, fromID, limit, true)
+}
+
+func (s *store) ListLen(key string) (int, error) {
+	return s.ListLenCtx(context.Background(), key)
+}
+
+func (s *store) ListLenCtx(ctx context.Context, key string) (int, error) {
+	if s.ls == nil {
+		return 0, s.errNotReady()
+	}
+	return s.ls.listLen(ctx, key)
+}
+
+func (s *store) ListContains(key string, id string) (bool, error) {
+	return s.ListContainsCtx(context.Background(), key, id)
+}
+
+func (s *store) ListContainsCtx(ctx context.Context, key string, id string) (bool, error) {
+	if s.ls == nil {
+		return false, s.errNotReady()
+	}
+	_, found, err := s.ls.listGetItem(ctx, key, id)
+	return found, err
+}
+
+func (s *store) ListGetItem(key string, id string) (*Item, bool, error) {
+	return s.ListGetItemCtx(context.Background(), key, id)
+}
+
+func (s *store) ListGetItemCtx(ctx context.Context, key string, id string) (*Item, bool, error) {
+	if s.ls == nil {
+		return nil, false, s.errNotReady()
+	}
+	return s.ls.listGetItem(ctx, key, id)
+}
+
+func (s *store) LPush(key string, values ...*Item) (int, error) {
+	return s.LPushCtx(context.Background(), key, values...)
+}
+
+func (s *store) LPushCtx(ctx context.Context, key string, values ...*Item) (int, error) {
+	return s.push(ctx, seqLPush, key, values)
+}
+
+func (s *store) RPush(key string, values ...*Item) (int, error) {
+	return s.RPushCtx(context.Background(), key, values...)
+}
+
+func (s *store) RPushCtx(ctx context.Context, key string, values ...*Item) (int, error) {
+	return s.push(ctx, seqRPush, key, values)
+}
+
+func (s *store) push(ctx context.Context, op seqOp, key string, values []*Item) (int, error) {
+	if s.ls == nil {
+		return 0, s.errNotReady()
+	}
+	items, err := seqItems(values, s.opts.now())
+	if err != nil {
+		return 0, err
+	}
+	r, err := s.ls.seq(ctx, seqReq{op: op, key: key, items: items})
+	return r.n, err
+}
+
+func (s *store) LPop(key string) (*Item, bool, error) {
+	return s.LPopCtx(context.Background(), key)
+}
+
+func (s *store) LPopCtx(ctx context.Context, key string) (*Item, bool, error) {
+	return s.seqItem(ctx, seqReq{op: seqLPop, key: key})
+}
+
+func (s *store) RPop(key string) (*Item, bool, error) {
+	return s.RPopCtx(context.Background(), key)
+}
+
+func (s *store) RPopCtx(ctx context.Context, key string) (*Item, bool, error) {
+	return s.seqItem(ctx, seqReq{op: seqRPop, key: key})
+}
+
+func (s *store) LIndex(key string, index int) (*Item, bool, error) {
+	return s.LIndexCtx(context.Background(), key, index)
+}
+
+func (s *store) LIndexCtx(ctx context.Context, key string, index int) (*Item, bool, error) {
+	return s.seqItem(ctx, seqReq{op: seqIndex, key: key, start: index})
+}
+
+func (s *store) seqItem(ctx context.Context, req seqReq) (*Item, bool, error) {
+	if s.ls == nil {
+		return nil, false, s.errNotReady()
+	}
+	r, err := s.ls.seq(ctx, req)
+	if err != nil || !r.found {
+		return nil, false, err
+	}
+	return &r.items[0], true, nil
+}
+
+func (s *store) LRange(key string, start, stop int) ([]*Item, error) {
+	return s.LRangeCtx(context.Background(), key, start, stop)
+}
+
+func (s *store) LRangeCtx(ctx context.Context, key string, start, stop int) ([]*Item, error) {
+	if s.ls == nil {
+		return nil, s.errNotReady()
+	}
+	r, err := s.ls.seq(ctx, seqReq{op: seqRange, key: key, start: start, stop: stop})
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*Item, len(r.items))
+	for i := range r.items {
+		items[i] = &r.items[i]
+	}
+	return items, nil
+}
+
+func (s *store) LTrim(key string, start, stop int) error {
+	return s.LTrimCtx(context.Background(), key, start, stop)
+}
+
+func (s *store) LTrimCtx(ctx context.Context, key string, start, stop int) error {
+	if s.ls == nil {
+		return s.errNotReady()
+	}
+	_, err := s.ls.seq(ctx, seqReq{op: seqTrim, key: key, start: start, stop: stop})
+	return err
+}
+
+func (s *store) LInsert(key string, pos InsertPosition, pivotID string, value *Item) (int, error) {
+	return s.LInsertCtx(context.Background(), key, pos, pivotID, value)
+}
+
+func (s *store) LInsertCtx(ctx context.Context, key string, pos InsertPosition, pivotID string, value *Item) (int, error) {
+	if s.ls == nil {
+		return 0, s.errNotReady()
+	}
+	items, err := seqItems([]*Item{value}, s.opts.now())
+	if err != nil {
+		return 0, err
+	}
+	r, err := s.ls.seq(ctx, seqReq{op: seqInsert, key: key, items: items, pivot: pivotID, after: pos == InsertAfter})
+	return r.n, err
+}
+
+func (s *store) LLen(key string) (int, error) {
+	return s.LLenCtx(context.Background(), key)
+}
+
+func (s *store) LLenCtx(ctx context.Context, key string) (int, error) {
+	if s.ls == nil {
+		return 0, s.errNotReady()
+	}
+	r, err := s.ls.seq(ctx, seqReq{op: seqLen, key: key})
+	return r.n, err
+}
+
+func (s *store) ZAdd(key string, id string, score float64, value interface{}) (bool, error) {
+	return s.ZAddCtx(context.Background(), key, id, score, value)
+}
+
+func (s *store) ZAddCtx(ctx context.Context, key string, id string, score float64, value interface{}) (bool, error) {
+	if s.ls == nil {
+		return false, s.errNotReady()
+	}
+	if len(id) == 0 {
+		return false, fmt.Errorf("invalid input")
+	}
+	item := newListItem(&Item{ID: id, Value: value}, 0, s.opts.now())
+	r, err := s.ls.zset(ctx, zsetReq{op: zAdd, key: key, item: item, score: score})
+	return r.found, err
+}
+
+func (s *store) ZIncrBy(key string, id string, delta float64) (float64, error) {
+	return s.ZIncrByCtx(context.Background(), key, id, delta)
+}
+
+func (s *store) ZIncrByCtx(ctx context.Context, key string, id string, delta float64) (float64, error) {
+	if s.ls == nil {
+		return 0, s.errNotReady()
+	}
+	if len(id) == 0 {
+		return 0, fmt.Errorf("invalid input")
+	}
+	item := newListItem(&Item{ID: id}, 0, s.opts.now())
+	r, err := s.ls.zset(ctx, zsetReq{op: zIncrBy, key: key, item: item, score: delta})
+	return r.score, err
+}
+
+func (s *store) ZRangeByScore(key string, min, max float64) ([]ZMember, error) {
+	return s.ZRangeByScoreCtx(context.Background(), key, min, max)
+}
+
+func (s *store) ZRangeByScoreCtx(ctx context.Context, key string, min, max float64) ([]ZMember, error) {
+	if s.ls == nil {
+		return nil, s.errNotReady()
+	}
+	r, err := s.ls.zset(ctx, zsetReq{op: zRangeByScore, key: key, score: min, max: max})
+	if r.members == nil {
+		r.members = make([]ZMember, 0)
+	}
+	return r.members, err
+}
+
+func (s *store) ZRank(key string, id string) (int, bool, error) {
+	return s.ZRankCtx(context.Background(), key, id)
+}
+
+func (s *store) ZRankCtx(ctx context.Context, key string, id string) (int, bool, error) {
+	if s.ls == nil {
+		return 0, false, s.errNotReady()
+	}
+	r, err := s.ls.zset(ctx, zsetReq{op: zRank, key: key, item: Item{ID: id}})
+	return r.n, r.found, err
+}
+
+func (s *store) ZRem(key string, ids ...string) (int, error) {
+	return s.ZRemCtx(context.Background(), key, ids...)
+}
+
+func (s *store) ZRemCtx(ctx context.Context, key string, ids ...string) (int, error) {
+	if s.ls == nil {
+		return 0, s.errNotReady()
+	}
+	r, err := s.ls.zset(ctx, zsetReq{op: zRem, key: key, ids: ids})
+	return r.n, err
+}
+
+func (s *store) ZPopMin(key string) (ZMember, bool, error) {
+	return s.ZPopMinCtx(context.Background(), key)
+}
+
+func (s *store) ZPopMinCtx(ctx context.Context, key string) (ZMember, bool, error) {
+	return s.zpop(ctx, zPopMin, key)
+}
+
+func (s *store) ZPopMax(key string) (ZMember, bool, error) {
+	return s.ZPopMaxCtx(context.Background(), key)
+}
+
+func (s *store) ZPopMaxCtx(ctx context.Context, key string) (ZMember, bool, error) {
+	return s.zpop(ctx, zPopMax, key)
+}
+
+func (s *store) zpop(ctx context.Context, op zsetOp, key string) (ZMember, bool, error) {
+	if s.ls == nil {
+		return ZMember{}, false, s.errNotReady()
+	}
+	r, err := s.ls.zset(ctx, zsetReq{op: op, key: key})
+	if err != nil || !r.found {
+		return ZMember{}, false, err
+	}
+	return r.members[0], true, nil
+}
+
+func (s *store) OnZSetDidChange(cb func(string, []ZMember)) func() {
+	return s.onEvent(eventZSetChange, func(e Event) {
+		cb(e.Key, e.members)
+	})
+}
+
+func (s *store) HSet(key string, field string, value interface{}) (bool, error) {
+	return s.HSetCtx(context.Background(), key, field, value)
+}
+
+func (s *store) HSetCtx(ctx context.Context, key string, field string, value interface{}) (bool, error) {
+	if s.kv == nil {
+		return false, s.errNotReady()
+	}
+	if len(field) == 0 {
+		return false, fmt.Errorf("invalid input")
+	}
+	r, err := s.kv.runHash(ctx, hashReq{op: hSet, key: key, fields: []string{field}, value: value})
+	return r.found, err
+}
+
+func (s *store) HGet(key string, field string) (interface{}, bool, error) {
+	return s.HGetCtx(context.Background(), key, field)
+}
+
+func (s *store) HGetCtx(ctx context.Context, key string, field string) (interface{}, bool, error) {
+	if s.kv == nil {
+		return nil, false, s.errNotReady()
+	}
+	r, err := s.kv.runHash(ctx, hashReq{op: hGet, key: key, fields: []string{field}})
+	return r.value, r.found, err
+}
+
+func (s *store) HDel(key string, fields ...string) (int, error) {
+	return s.HDelCtx(context.Background(), key, fields...)
+}
+
+func (s *store) HDelCtx(ctx context.Context, key string, fields ...string) (int, error) {
+	if s.kv == nil {
+		return 0, s.errNotReady()
+	}
+	r, err := s.kv.runHash(ctx, hashReq{op: hDel, key: key, fields: fields})
+	return int(r.n), err
+}
+
+func (s *store) HGetAll(key string) (map[string]interface{}, error) {
+	return s.HGetAllCtx(context.Background(), key)
+}
+
+func (s *store) HGetAllCtx(ctx context.Context, key string) (map[string]interface{}, error) {
+	if s.kv == nil {
+		return nil, s.errNotReady()
+	}
+	r, err := s.kv.runHash(ctx, hashReq{op: hGetAll, key: key})
+	if r.fields == nil {
+		r.fields = make(map[string]interface{})
+	}
+	return r.fields, err
+}
+
+func (s *store) HIncrBy(key string, field string, delta int64) (int64, error) {
+	return s.HIncrByCtx(context.Background(), key, field, delta)
+}
+
+func (s *store) HIncrByCtx(ctx context.Context, key string, field string, delta int64) (int64, error) {
+	if s.kv == nil {
+		return 0, s.errNotReady()
+	}
+	if len(field) == 0 {
+		return 0, fmt.Errorf("invalid input")
+	}
+	r, err := s.kv.runHash(ctx, hashReq{op: hIncrBy, key: key, fields: []string{field}, delta: delta})
+	return r.n, err
+}
+
+func (s *store) HLen(key string) (int, error) {
+	return s.HLenCtx(context.Background(), key)
+}
+
+func (s *store) HLenCtx(ctx context.Context, key string) (int, error) {
+	if s.kv == nil {
+		return 0, s.errNotReady()
+	}
+	r, err := s.kv.runHash(ctx, hashReq{op: hLen, key: key})
+	return int(r.n), err
+}
+
+func (s *store) HKeys(key string) ([]string, error) {
+	return s.HKeysCtx(context.Background(), key)
+}
+
+func (s *store) HKeysCtx(ctx context.Context, key string) ([]string, error) {
+	if s.kv == nil {
+		return nil, s.errNotReady()
+	}
+	r, err := s.kv.runHash(ctx, hashReq{op: hKeys, key: key})
+	if r.keys == nil {
+		r.keys = make([]string, 0)
+	}
+	return r.keys, err
+}
+
+func (s *store) SAdd(key string, members ...string) (int, error) {
+	return s.SAddCtx(context.Background(), key, members...)
+}
+
+func (s *store) SAddCtx(ctx context.Context, key string, members ...string) (int, error) {
+	return s.changeSet(ctx, sAdd, key, members)
+}
+
+func (s *store) SRem(key string, members ...string) (int, error) {
+	return s.SRemCtx(context.Background(), key, members...)
+}
+
+func (s *store) SRemCtx(ctx context.Context, key string, members ...string) (int, error) {
+	return s.changeSet(ctx, sRem, key, members)
+}
+
+func (s *store) changeSet(ctx context.Context, op setOp, key string, members []string) (int, error) {
+	if s.kv == nil {
+		return 0, s.errNotReady()
+	}
+	if len(members) == 0 {
+		return 0, fmt.Errorf("invalid input")
+	}
+	r, err := s.kv.runSet(ctx, stringSetReq{op: op, keys: []string{key}, members: members})
+	return r.n, err
+}
+
+func (s *store) SIsMember(key string, member string) (bool, error) {
+	return s.SIsMemberCtx(context.Background(), key, member)
+}
+
+func (s *store) SIsMemberCtx(ctx context.Context, key string, member string) (bool, error) {
+	if s.kv == nil {
+		return false, s.errNotReady()
+	}
+	r, err := s.kv.runSet(ctx, stringSetReq{op: sIsMember, keys: []string{key}, members: []string{member}})
+	return r.found, err
+}
+
+func (s *store) SCard(key string) (int, error) {
+	return s.SCardCtx(context.Background(), key)
+}
+
+func (s *store) SCardCtx(ctx context.Context, key string) (int, error) {
+	if s.kv == nil {
+		return 0, s.errNotReady()
+	}
+	r, err := s.kv.runSet(ctx, stringSetReq{op: sCard, keys: []string{key}})
+	return r.n, err
+}
+
+func (s *store) SMembers(key string) ([]string, error) {
+	return s.SMembersCtx(context.Background(), key)
+}
+
+func (s *store) SMembersCtx(ctx context.Context, key string) ([]string, error) {
+	return s.setMembers(ctx, sMembers, []string{key})
+}
+
+func (s *store) SInter(keys ...string) ([]string, error) {
+	return s.SInterCtx(context.Background(), keys...)
+}
+
+func (s *store) SInterCtx(ctx context.Context, keys ...string) ([]string, error) {
+	return s.setMembers(ctx, sInter, keys)
+}
+
+func (s *store) SUnion(keys ...string) ([]string, error) {
+	return s.SUnionCtx(context.Background(), keys...)
+}
+
+func (s *store) SUnionCtx(ctx context.Context, keys ...string) ([]string, error) {
+	return s.setMembers(ctx, sUnion, keys)
+}
+
+func (s *store) SDiff(keys ...string) ([]string, error) {
+	return s.SDiffCtx(context.Background(), keys...)
+}
+
+func (s *store) SDiffCtx(ctx context.Context, keys ...string) ([]string, error) {
+	return s.setMembers(ctx, sDiff, keys)
+}
+
+func (s *store) setMembers(ctx context.Context, op setOp, keys []string) ([]string, error) {
+	if s.kv == nil {
+		return nil, s.errNotReady()
+	}
+	r, err := s.kv.runSet(ctx, stringSetReq{op: op, keys: keys})
+	if r.members == nil {
+		r.members = make([]string, 0)
+	}
+	return r.members, err
+}
+
+func (s *store) SInterStore(dest string, keys ...string) (int, error) {
+	return s.SInterStoreCtx(context.Background(), dest, keys...)
+}
+
+func (s *store) SInterStoreCtx(ctx context.Context, dest string, keys ...string) (int, error) {
+	return s.storeSet(ctx, sInter, dest, keys)
+}
+
+func (s *store) SUnionStore(dest string, keys ...string) (int, error) {
+	return s.SUnionStoreCtx(context.Background(), dest, keys...)
+}
+
+func (s *store) SUnionStoreCtx(ctx context.Context, dest string, keys ...string) (int, error) {
+	return s.storeSet(ctx, sUnion, dest, keys)
+}
+
+func (s *store) SDiffStore(dest string, keys ...string) (int, error) {
+	return s.SDiffStoreCtx(context.Background(), dest, keys...)
+}
+
+func (s *store) SDiffStoreCtx(ctx context.Context, dest string, keys ...string) (int, error) {
+	return s.storeSet(ctx, sDiff, dest, keys)
+}
+
+func (s *store) storeSet(ctx context.Context, op setOp, dest string, keys []string) (int, error) {
+	if s.kv == nil {
+		return 0, s.errNotReady()
+	}
+	if len(dest) == 0 {
+		return 0, fmt.Errorf("invalid input")
+	}
+	r, err := s.kv.runSet(ctx, stringSetReq{op: op, keys: keys, dest: dest})
+	return r.n, err
+}
+
+func (s *store) BlockingPop(ctx context.Context, keys []string) (string, *Item, error) {
+	if s.ls == nil {
+		return "", nil, s.errNotReady()
+	}
+	return s.ls.blockingPop(ctx, keys)
+}
+
+func (s *store) OnItemDidExpire(cb func(item *Item)) func() {
+	return s.onEvent(EventExpire, func(e Event) {
+		cb(e.Old)
+	})
+}
+
+func (s *store) OnItemEvicted(cb func(item *Item)) func() {
+	return s.onEvent(EventEvict, func(e Event) {
+		cb(e.Old)
+	})
+}
+
+func (s *store) OnListDidChange(cb func(string, []*Item)) func() {
+	return s.onEvent(eventListChange, func(e Event) {
+		cb(e.Key, e.items)
+	})
+}
+
+func (s *store) OnListDiff(cb func(ListDiff)) func() {
+	return s.onEvent(eventListDiff, func(e Event) {
+		cb(*e.diff)
+	})
+}
+
+func (s *store) OnListItemDidExpire(cb func(string, *Item)) func() {
+	return s.onEvent(EventListExpire, func(e Event) {
+		cb(e.Key, e.Old)
+	})
+}
+
+// onEvent calls fn with the events of the given kinds, in order, until the
+// returned function is called
+func (s *store) onEvent(kinds EventKind, fn func(Event)) func() {
+	if s.events == nil {
+		panic(fmt.Errorf("Init not yet called"))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := s.events.subscribe(ctx, EventFilter{Kinds: kinds}, s.cfg.Delivery, func(_ context.Context, e Event) {
+		fn(e)
+	})
+	return func() {
+		// stop the delivery before returning so no later event reaches fn
+		sub.abort()
+		cancel()
+	}
+}
+
+func (s *store) Snapshot(w io.Writer) error {
+	if s.kv == nil || s.ls == nil {
+		return s.errNotReady()
+	}
+	recs, err := s.dumpRecords(nil)
+	if err != nil {
+		return err
+	}
+	return writeSnapshot(w, recs, s.opts.now())
+}
+
+func (s *store) Restore(r io.Reader) error {
+	if s.kv == nil || s.ls == nil {
+		return s.errNotReady()
+	}
+	items, lc, err := readSnapshot(r, s.opts.now())
+	if err != nil {
+		return err
+	}
+	if err := s.replaceContents(items, lc); err != nil {
+		return err
+	}
+	if s.wlog != nil {
+		// the restore is already in the log, the rewrite only drops the
+		// history it replaced
+		if err := s.wlog.rewrite(); err != nil {
+			s.opts.logf("ERROR: log rewrite failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// replaceContents replaces the items and the lists while both event loops
+// are paused, so no write lands between the two. The new contents are logged
+// as a single opReplace record first, so a crash either keeps the old
+// contents or the new ones.
+func (s *store) replaceContents(items []Item, lc listContents) error {
+	release, err := s.pauseAll(context.Background())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := s.wlog.append(record{Op: opReplace, Batch: snapshotRecords(items, lc)}); err != nil {
+		return err
+	}
+	s.kv.replaceItems(items)
+	s.ls.replaceLists(lc)
+	for key := range s.ls.waiters {
+		s.ls.serveWaiters(key)
+	}
+	return nil
+}
+
+func (s *store) SnapshotFile(path string) error {
+	return writeFileAtomic(path, s.Snapshot)
+}
+
+func (s *store) RestoreFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.Restore(f)
+}
+
+func (s *store) Watch(ctx context.Context, filter EventFilter) <-chan Event {
+	return s.WatchWithOptions(ctx, filter, s.cfg.Delivery)
+}
+
+func (s *store) WatchWithOptions(ctx context.Context, filter EventFilter, opts DeliveryOptions) <-chan Event {
+	if s.events == nil {
+		panic(fmt.Errorf("Init not yet called"))
+	}
+	return s.events.watch(ctx, filter, opts)
+}
+
+func (s *store) DroppedEvents() uint64 {
+	if s.events == nil {
+		return 0
+	}
+	return s.events.droppedEvents()
+}
+
+func (s *store) MemoryUsage(key string) (int64, bool, error) {
+	return s.MemoryUsageCtx(context.Background(), key)
+}
+
+func (s *store) MemoryUsageCtx(ctx context.Context, key string) (int64, bool, error) {
+	if s.kv == nil || s.ls == nil {
+		return 0, false, s.errNotReady()
+	}
+	if len(key) == 0 {
+		return 0, false, fmt.Errorf("invalid input")
+	}
+	items, err := s.kv.memoryUsage(ctx, key)
+	if err != nil {
+		return 0, false, err
+	}
+	lists, err := s.ls.memoryUsage(ctx, key)
+	if err != nil {
+		return 0, false, err
+	}
+	return items.size + lists.size, items.found || lists.found, nil
+}
+
+func (s *store) Stats() (Stats, error) {
+	return s.StatsCtx(context.Background())
+}
+
+func (s *store) StatsCtx(ctx context.Context) (Stats, error) {
+	if s.kv == nil || s.ls == nil {
+		return Stats{}, s.errNotReady()
+	}
+	items, err := s.kv.memoryUsage(ctx, "")
+	if err != nil {
+		return Stats{}, err
+	}
+	lists, err := s.ls.memoryUsage(ctx, "")
+	if err != nil {
+		return Stats{}, err
+	}
+	return Stats{
+		Items:     items.count,
+		ItemBytes: items.bytes,
+		Lists:     lists.count,
+		ListBytes: lists.bytes,
+		Bytes:     items.bytes + lists.bytes,
+	}, nil
+}
+
+func (s *store) CompactLog() error {
+	if s.kv == nil || s.ls == nil {
+		return s.errNotReady()
+	}
+	if s.wlog == nil {
+		return fmt.Errorf("append-only log is not enabled")
+	}
+	return s.wlog.rewrite()
+}
+
+// dumpRecords returns the current contents of the store as records. Both
+// event loops are paused while the contents are copied, so the records never
+// hold part of a batch. start, if not nil, is called while they are paused.
+func (s *store) dumpRecords(start func()) ([]record, error) {
+	release, err := s.pauseAll(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if start != nil {
+		start()
 	}
+	items := s.kv.dumpItems()
+	lc := s.ls.dumpLists()
+	release()
+	return snapshotRecords(items, lc), nil
 }
@@ -183,7 +183,7 @@ var _ = Describe("GoStore", func() {
 	It("Should call OnListDidChange when adding an item to a list", func(done Done) {
 		store.Init()
 		c := make(chan string)
-		store.OnListDidChange(func(key string, items []*gostore.Item) {
+		cancel := store.OnListDidChange(func(key string, items []*gostore.Item) {
 			defer GinkgoRecover()
 			Expect(len(items)).To(Equal(1))
 			if len(items) == 1 {
@@ -195,6 +195,7 @@ var _ = Describe("GoStore", func() {
 		store.ListPush("one", &gostore.Item{ID: "a", Value: "a data"})
 		Expect(<-c).To(Equal("one"))
 
+		cancel()
 		store.OnListDidChange(func(key string, items []*gostore.Item) {
 			defer GinkgoRecover()
 			Expect(len(items)).To(Equal(2))
@@ -238,7 +239,7 @@ var _ = Describe("GoStore", func() {
 		c := make(chan string)
 
 		// add 1st item
-		store.OnListDidChange(func(key string, items []*gostore.Item) {
+		cancel := store.OnListDidChange(func(key string, items []*gostore.Item) {
 			defer GinkgoRecover()
 			Expect(len(items)).To(Equal(1))
 			if len(items) == 1 {
@@ -251,7 +252,8 @@ var _ = Describe("GoStore", func() {
 		Expect(<-c).To(Equal("one"))
 
 		// add 2nd item
-		store.OnListDidChange(func(key string, items []*gostore.Item) {
+		cancel()
+		cancel = store.OnListDidChange(func(key string, items []*gostore.Item) {
 			defer GinkgoRecover()
 			Expect(len(items)).To(Equal(2))
 			if len(items) == 2 {
@@ -266,7 +268,8 @@ var _ = Describe("GoStore", func() {
 		Expect(<-c).To(Equal("one"))
 
 		// remove 1st item
-		store.OnListDidChange(func(key string, items []*gostore.Item) {
+		cancel()
+		cancel = store.OnListDidChange(func(key string, items []*gostore.Item) {
 			defer GinkgoRecover()
 			Expect(len(items)).To(Equal(1))
 			if len(items) == 1 {
@@ -279,6 +282,7 @@ var _ = Describe("GoStore", func() {
 		Expect(<-c).To(Equal("one"))
 
 		// remove again should not trigger OnListDidChange
+		cancel()
 		store.OnListDidChange(func(key string, items []*gostore.Item) {
 			c <- key
 		})
@@ -288,4 +292,49 @@ var _ = Describe("GoStore", func() {
 		close(done)
 	})
 
+	It("Should call every OnListDidChange callback until it is cancelled", func(done Done) {
+		store.Init()
+		c1 := make(chan string, 10)
+		c2 := make(chan string, 10)
+		cancel1 := store.OnListDidChange(func(key string, items []*gostore.Item) {
+			c1 <- key
+		})
+		store.OnListDidChange(func(key string, items []*gostore.Item) {
+			c2 <- key
+		})
+
+		store.ListPush("one", &gostore.Item{ID: "a", Value: "a data"})
+		Expect(<-c1).To(Equal("one"))
+		Expect(<-c2).To(Equal("one"))
+
+		cancel1()
+		cancel1()
+		store.ListPush("one", &gostore.Item{ID: "b", Value: "b data"})
+		Expect(<-c2).To(Equal("one"))
+		Consistently(c1).ShouldNot(Receive())
+
+		close(done)
+	})
+
+	It("Should call every OnItemDidExpire callback until it is cancelled", func() {
+		store.Init()
+		c1 := make(chan string, 10)
+		c2 := make(chan string, 10)
+		cancel1 := store.OnItemDidExpire(func(item *gostore.Item) {
+			c1 <- item.Key
+		})
+		store.OnItemDidExpire(func(item *gostore.Item) {
+			c2 <- item.Key
+		})
+
+		store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "data"}, 50*time.Millisecond)
+		Eventually(c1, "1s").Should(Receive(Equal("k1")))
+		Eventually(c2, "1s").Should(Receive(Equal("k1")))
+
+		cancel1()
+		store.Put(&gostore.Item{Key: "k2", ID: "2", Value: "data"}, 50*time.Millisecond)
+		Eventually(c2, "1s").Should(Receive(Equal("k2")))
+		Consistently(c1).ShouldNot(Receive())
+	})
+
 })
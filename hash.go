@@ -0,0 +1,154 @@
+package gostore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// applyHash runs an operation on the hash for the key
+func (s *kvStore) applyHash(r hashReq, now time.Time) hashResp {
+	if i, ok := s.kval[r.key]; ok && s.isExpired(i, now) {
+		s.expireItems(now)
+	}
+	i, ok := s.kval[r.key]
+	if ok && i.kind != kindHash {
+		return hashResp{err: ErrWrongKind}
+	}
+	if !ok && r.op != hSet && r.op != hIncrBy {
+		return hashResp{}
+	}
+	fields := i.fields()
+
+	switch r.op {
+	case hSet, hIncrBy:
+		field := r.fields[0]
+		old, exists := fields[field]
+		value := r.value
+		if r.op == hIncrBy {
+			var n int64
+			if exists {
+				var isInt bool
+				if n, isInt = toInt64(old); !isInt {
+					return hashResp{err: &NotNumericError{Key: r.key, Field: field, Value: old, integer: true}}
+				}
+			}
+			sum, err := addInt64(n, r.delta)
+			if err != nil {
+				return hashResp{err: err}
+			}
+			value = sum
+		}
+		size := i.size + s.estimate.fieldSize(field, value)
+		if !ok {
+			size = s.estimate.itemSize(&Item{Key: r.key}) + s.estimate.fieldSize(field, value)
+		} else if exists {
+			size -= s.estimate.fieldSize(field, old)
+		}
+		if err := s.makeRoom(r.key, size, now); err != nil {
+			return hashResp{err: err}
+		}
+		if err := s.wlog.append(record{Op: opHSet, Key: r.key, ID: field, Value: value}); err != nil {
+			return hashResp{err: err}
+		}
+		if !ok {
+			i = Item{Key: r.key, Value: make(map[string]interface{}), kind: kindHash}
+			fields = i.fields()
+		}
+		fields[field] = value
+		s.modified(&i)
+		s.setItem(i)
+		newField := &Item{ID: field, Key: r.key, Value: value}
+		if exists {
+			s.events.publish(EventHSet, r.key, &Item{ID: field, Key: r.key, Value: old}, newField)
+		} else {
+			s.events.publish(EventHSet, r.key, nil, newField)
+		}
+		n, _ := value.(int64)
+		return hashResp{value: value, n: n, found: !exists}
+
+	case hDel:
+		var recs []record
+		for _, field := range r.fields {
+			if _, ok := fields[field]; ok && !hasField(recs, field) {
+				recs = append(recs, record{Op: opHDel, Key: r.key, ID: field})
+			}
+		}
+		if len(recs) == 0 {
+			return hashResp{}
+		}
+		if err := s.wlog.append(batchRecord(recs)); err != nil {
+			return hashResp{err: err}
+		}
+		for _, rec := range recs {
+			old := fields[rec.ID]
+			delete(fields, rec.ID)
+			s.events.publish(EventHDel, r.key, &Item{ID: rec.ID, Key: r.key, Value: old}, nil)
+		}
+		if len(fields) == 0 {
+			s.deleteItem(r.key)
+			s.events.publish(EventDel, r.key, &i, nil)
+		} else {
+			s.modified(&i)
+			s.setItem(i)
+		}
+		return hashResp{n: int64(len(recs))}
+	}
+
+	s.accessed(&i, now)
+	switch r.op {
+	case hGet:
+		value, found := fields[r.fields[0]]
+		return hashResp{value: value, found: found}
+
+	case hGetAll:
+		return hashResp{fields: i.detached().fields(), found: true}
+
+	case hLen:
+		return hashResp{n: int64(len(fields)), found: true}
+
+	case hKeys:
+		keys := make([]string, 0, len(fields))
+		for field := range fields {
+			keys = append(keys, field)
+		}
+		sort.Strings(keys)
+		return hashResp{keys: keys, found: true}
+	}
+	return hashResp{}
+}
+
+func hasField(recs []record, field string) bool {
+	for _, rec := range recs {
+		if rec.ID == field {
+			return true
+		}
+	}
+	return false
+}
+
+// runHash sends an operation on a hash to the event loop
+func (s *kvStore) runHash(ctx context.Context, req hashReq) (hashResp, error) {
+	if len(req.key) == 0 {
+		return hashResp{}, fmt.Errorf("invalid input")
+	}
+	req.resp = make(chan hashResp, 1)
+	if err := ctx.Err(); err != nil {
+		return hashResp{}, err
+	}
+	select {
+	case s.hash <- req:
+	case <-ctx.Done():
+		return hashResp{}, ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
+		return hashResp{}, fmt.Errorf("Hash channel timeout")
+	}
+	var r hashResp
+	select {
+	case r = <-req.resp:
+	case <-ctx.Done():
+		return hashResp{}, ctx.Err()
+	}
+	return r, r.err
+}
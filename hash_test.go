@@ -0,0 +1,190 @@
+package gostore_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tonjun/gostore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Hashes", func() {
+
+	var store gostore.Store
+
+	BeforeEach(func() {
+		store = gostore.NewStore()
+		store.Init()
+	})
+
+	AfterEach(func() {
+		store.Close()
+	})
+
+	It("HSet() and HGet() should set and read single fields", func() {
+		Expect(store.HSet("user:1", "name", "alice")).To(BeTrue())
+		Expect(store.HSet("user:1", "age", 30)).To(BeTrue())
+		Expect(store.HSet("user:1", "name", "bob")).To(BeFalse())
+
+		value, found, err := store.HGet("user:1", "name")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(value).To(Equal("bob"))
+
+		_, found, _ = store.HGet("user:1", "missing")
+		Expect(found).To(BeFalse())
+		_, found, _ = store.HGet("user:2", "name")
+		Expect(found).To(BeFalse())
+	})
+
+	It("HGetAll(), HLen() and HKeys() should return the fields", func() {
+		store.HSet("h", "b", 2)
+		store.HSet("h", "a", 1)
+
+		fields, err := store.HGetAll("h")
+		Expect(err).To(BeNil())
+		Expect(fields).To(Equal(map[string]interface{}{"a": 1, "b": 2}))
+
+		// the returned map is a copy
+		fields["c"] = 3
+		Expect(store.HLen("h")).To(Equal(2))
+		Expect(store.HKeys("h")).To(Equal([]string{"a", "b"}))
+
+		Expect(store.HGetAll("missing")).To(BeEmpty())
+		Expect(store.HKeys("missing")).To(BeEmpty())
+	})
+
+	It("HDel() should remove fields and the hash with its last field", func() {
+		store.HSet("h", "a", 1)
+		store.HSet("h", "b", 2)
+
+		Expect(store.HDel("h", "a", "a", "missing")).To(Equal(1))
+		Expect(store.HKeys("h")).To(Equal([]string{"b"}))
+
+		Expect(store.HDel("h", "b")).To(Equal(1))
+		Expect(store.Keys("*")).To(BeEmpty())
+	})
+
+	It("Concurrent HIncrBy() calls should not lose updates", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					_, err := store.HIncrBy("counters", "visits", 1)
+					Expect(err).To(BeNil())
+				}
+			}()
+		}
+		wg.Wait()
+
+		Expect(store.HIncrBy("counters", "visits", -50)).To(Equal(int64(50)))
+
+		store.HSet("counters", "name", "x")
+		_, err := store.HIncrBy("counters", "name", 1)
+		Expect(gostore.IsNotNumeric(err)).To(BeTrue())
+	})
+
+	It("HIncrBy() should fail instead of overflowing", func() {
+		store.HIncrBy("counters", "n", math.MaxInt64)
+		_, err := store.HIncrBy("counters", "n", 1)
+		Expect(err).NotTo(BeNil())
+		Expect(store.HIncrBy("counters", "n", -1)).To(Equal(int64(math.MaxInt64 - 1)))
+	})
+
+	It("Hashes should not share a key with plain items", func() {
+		store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)
+		_, err := store.HSet("k1", "f", "v")
+		Expect(err).To(Equal(gostore.ErrWrongKind))
+
+		store.HSet("h", "f", "v")
+		_, _, err = store.Get("h")
+		Expect(err).To(Equal(gostore.ErrWrongKind))
+	})
+
+	It("A hash should expire with its key", func() {
+		store.HSet("h", "f", "v")
+		Expect(store.Expire("h", 50*time.Millisecond)).To(BeTrue())
+		store.HSet("h", "g", "w")
+
+		Eventually(func() int {
+			n, _ := store.HLen("h")
+			return n
+		}).Should(Equal(0))
+	})
+
+	It("Watch() should report every field change", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events := store.Watch(ctx, gostore.EventFilter{})
+
+		store.HSet("h", "f", "v1")
+		store.HSet("h", "f", "v2")
+		store.HDel("h", "f")
+
+		var e gostore.Event
+		Eventually(events).Should(Receive(&e))
+		Expect(e.Kind).To(Equal(gostore.EventHSet))
+		Expect(e.Key).To(Equal("h"))
+		Expect(e.Old).To(BeNil())
+		Expect(e.New.ID).To(Equal("f"))
+		Expect(e.New.Value).To(Equal("v1"))
+
+		Eventually(events).Should(Receive(&e))
+		Expect(e.Kind).To(Equal(gostore.EventHSet))
+		Expect(e.Old.Value).To(Equal("v1"))
+		Expect(e.New.Value).To(Equal("v2"))
+
+		Eventually(events).Should(Receive(&e))
+		Expect(e.Kind).To(Equal(gostore.EventHDel))
+		Expect(e.Old.ID).To(Equal("f"))
+		Expect(e.New).To(BeNil())
+
+		Eventually(events).Should(Receive(&e))
+		Expect(e.Kind).To(Equal(gostore.EventDel))
+		Expect(e.Key).To(Equal("h"))
+	})
+
+	It("Hashes should be saved in snapshots", func() {
+		store.HSet("h", "a", "va")
+		store.HSet("h", "b", "vb")
+
+		var buf bytes.Buffer
+		Expect(store.Snapshot(&buf)).To(BeNil())
+		store.HDel("h", "a")
+		Expect(store.Restore(&buf)).To(BeNil())
+
+		Expect(store.HGetAll("h")).To(Equal(map[string]interface{}{"a": "va", "b": "vb"}))
+	})
+
+	It("Hashes should be replayed from the log", func() {
+		dir, err := ioutil.TempDir("", "gostore")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		cfg := gostore.Config{LogPath: filepath.Join(dir, "store.log"), LogSync: gostore.SyncAlways}
+
+		logged := gostore.NewStoreWithConfig(cfg)
+		logged.Init()
+		logged.HSet("h", "a", "va")
+		logged.HIncrBy("h", "n", 5)
+		logged.HSet("h", "b", "vb")
+		logged.HDel("h", "b")
+		logged.Close()
+
+		logged = gostore.NewStoreWithConfig(cfg)
+		logged.Init()
+		defer logged.Close()
+		Expect(logged.HGetAll("h")).To(Equal(map[string]interface{}{"a": "va", "n": int64(5)}))
+	})
+
+})
@@ -10,5 +10,110 @@ type Item struct {
 	Key   string      // the key in the key/value store
 	Value interface{} // the value in the key/value store
 
-	expiresAt time.Time
+	expiresAt    time.Time     // when the item expires, the earlier of deadline and the idle limit
+	deadline     time.Time     // the absolute expiry of the item
+	ttl          time.Duration // the duration the item was last given to live
+	idle         time.Duration // the item expires when not read for this long
+	accessedAt   time.Time     // when the item was last read
+	expireReason ExpireReason
+	version      uint64 // the modification counter of a key/value item
+	kind         itemKind
+	size         int64 // the approximate memory used by a key/value item
+}
+
+// itemKind is the kind of value held by a key/value item
+type itemKind int
+
+const (
+	kindPlain itemKind = iota
+	kindHash           // Value is the map[string]interface{} of the fields
+	kindSet            // Value is the map[string]struct{} of the members
+)
+
+// detached returns a copy of the item that does not share its fields or
+// members with the store
+func (i Item) detached() Item {
+	switch i.kind {
+	case kindHash:
+		fields := make(map[string]interface{}, len(i.fields()))
+		for f, v := range i.fields() {
+			fields[f] = v
+		}
+		i.Value = fields
+	case kindSet:
+		members := make(map[string]struct{}, len(i.members()))
+		for m := range i.members() {
+			members[m] = struct{}{}
+		}
+		i.Value = members
+	}
+	return i
+}
+
+// fields returns the fields of a hash
+func (i Item) fields() map[string]interface{} {
+	fields, _ := i.Value.(map[string]interface{})
+	return fields
+}
+
+// members returns the members of a set
+func (i Item) members() map[string]struct{} {
+	members, _ := i.Value.(map[string]struct{})
+	return members
+}
+
+// ExpireReason tells why an item expired
+type ExpireReason int
+
+const (
+	// NotExpired is the reason reported for items that have not expired
+	NotExpired ExpireReason = iota
+
+	// ExpiredDeadline is reported for items that reached their absolute expiry
+	ExpiredDeadline
+
+	// ExpiredIdle is reported for items that were not read within their idle timeout
+	ExpiredIdle
+)
+
+// ExpireReason returns why the item expired. It is set on the items passed to
+// the OnItemDidExpire and OnListItemDidExpire callbacks.
+func (i *Item) ExpireReason() ExpireReason {
+	return i.expireReason
+}
+
+// setTTL sets the item to expire after the duration d, or never if d is 0
+func (i *Item) setTTL(now time.Time, d time.Duration) {
+	i.deadline = time.Time{}
+	i.ttl = 0
+	if d > 0 {
+		i.deadline = now.Add(d)
+		i.ttl = d
+	}
+	i.updateExpiry()
+}
+
+// access marks the item as read at now, extending its idle limit
+func (i *Item) access(now time.Time) {
+	i.accessedAt = now
+	i.updateExpiry()
+}
+
+// updateExpiry sets expiresAt to the earlier of the deadline and idle limit
+func (i *Item) updateExpiry() {
+	i.expiresAt = i.deadline
+	if i.idle > 0 {
+		at := i.accessedAt.Add(i.idle)
+		if i.expiresAt.IsZero() || at.Before(i.expiresAt) {
+			i.expiresAt = at
+		}
+	}
+}
+
+// expiredBy returns whether the idle limit or the deadline expired the item
+func (i *Item) expiredBy() ExpireReason {
+	if i.idle > 0 && !i.expiresAt.Equal(i.deadline) {
+		return ExpiredIdle
+	}
+	return ExpiredDeadline
 }
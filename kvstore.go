@@ -1,28 +1,48 @@
 package gostore
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"time"
 
 	"github.com/google/btree"
 )
 
 type kvStore struct {
-	kval         map[string]Item
-	set          chan setReq
-	get          chan getReq
-	del          chan delReq
-	close        chan bool
-	forExpiry    *btree.BTree // list of items to be checked for expiry
-	itemExpireCb func(*Item)
+	kval      map[string]Item
+	keys      *btree.BTree // the keys of kval in order
+	set       chan setReq
+	get       chan getReq
+	del       chan delReq
+	ttl       chan ttlReq
+	hold      chan holdReq
+	scan      chan scanReq
+	hash      chan hashReq
+	incr      chan incrReq
+	sets      chan stringSetReq
+	usage     chan usageReq
+	close     chan bool
+	forExpiry *expiryIndex      // keys of the items with an expiry, ordered by deadline
+	version   uint64            // incremented on every change to an item
+	watched   map[string]int    // the number of transactions watching each key
+	removed   map[string]uint64 // the versions of the watched keys that were deleted
+	bytes     int64             // the approximate memory used by the items
+	evict     *evictor          // optional limits and eviction policy
+	estimate  SizeEstimator
+	wlog      *appendLog // optional append-only log of applied mutations
+	events    *eventHub  // optional hub for keyspace events
+	opts      *settings
 }
 
-func newKVStore() *kvStore {
+func newKVStore(opts *settings) *kvStore {
 	return &kvStore{
 		kval:      make(map[string]Item),
-		forExpiry: btree.New(32),
+		keys:      btree.New(opts.degree),
+		forExpiry: newExpiryIndex(opts.degree),
+		watched:   make(map[string]int),
+		removed:   make(map[string]uint64),
 		close:     make(chan bool),
+		opts:      opts,
 	}
 }
 
@@ -30,46 +50,107 @@ func (s *kvStore) init() {
 	s.set = make(chan setReq)
 	s.get = make(chan getReq)
 	s.del = make(chan delReq)
+	s.ttl = make(chan ttlReq)
+	s.hold = make(chan holdReq)
+	s.scan = make(chan scanReq)
+	s.hash = make(chan hashReq)
+	s.incr = make(chan incrReq)
+	s.sets = make(chan stringSetReq)
+	s.usage = make(chan usageReq)
 
 	go func() {
-		ticker := time.NewTicker(500 * time.Millisecond)
+		timer := newExpiryTimer(s.opts)
 
 		defer func() {
 			//log.Println("kvStore closed")
-			ticker.Stop()
+			timer.stop()
 		}()
 
 		for {
+			// wake up exactly when the next item is due
+			timer.schedule(s.forExpiry)
+
 			select {
 			case r, ok := <-s.set:
 				if !ok {
 					return
 				}
-				s.kval[r.item.Key] = r.item
-				if !r.item.expiresAt.IsZero() {
-
-					// add to forExpiry tree
-					ti := treeItem{
-						Key:   r.item.Key,
-						Value: &r.item,
+				now := s.opts.now()
+				err := r.ctx.Err()
+				if err == nil {
+					err = s.checkCond(r.item.Key, r.cond, r.match, now)
+				}
+				if err == nil {
+					err = s.makeRoom(r.item.Key, s.estimate.itemSize(&r.item), now)
+				}
+				if err == nil {
+					err = s.wlog.append(itemRecord(opPut, r.item.Key, &r.item))
+				}
+				if err == nil {
+					old, exists := s.kval[r.item.Key]
+					s.modified(&r.item)
+					s.setItem(r.item)
+					if exists {
+						s.events.publish(EventOverwrite, r.item.Key, &old, &r.item)
+					} else {
+						s.events.publish(EventPut, r.item.Key, nil, &r.item)
 					}
-					s.forExpiry.ReplaceOrInsert(ti)
-
 				}
+				r.resp <- err
 
 			case r := <-s.get:
-				if val, ok := s.kval[r.key]; ok {
-					r.resp <- val
-				} else {
-					r.notFound <- true
+				now := s.opts.now()
+				if val, ok := s.kval[r.key]; ok && s.isExpired(val, now) {
+					s.expireItems(now)
+				}
+				val, ok := s.kval[r.key]
+				if ok {
+					s.accessed(&val, now)
 				}
+				r.resp <- getResp{item: val, found: ok}
 
 			case r := <-s.del:
-				s.deleteItem(r.key)
-				r.resp <- true
+				err := r.ctx.Err()
+				if err == nil {
+					err = s.checkCond(r.key, r.cond, r.match, s.opts.now())
+				}
+				if old, ok := s.kval[r.key]; ok && err == nil {
+					err = s.wlog.append(record{Op: opDel, Key: r.key})
+					if err == nil {
+						s.deleteItem(r.key)
+						s.events.publish(EventDel, r.key, &old, nil)
+					}
+				}
+				r.resp <- err
+
+			case r := <-s.ttl:
+				r.resp <- s.updateTTL(r, s.opts.now())
+
+			case r := <-s.scan:
+				s.expireItems(s.opts.now())
+				r.resp <- s.scanKeys(r)
+
+			case r := <-s.hash:
+				r.resp <- s.applyHash(r, s.opts.now())
+
+			case r := <-s.incr:
+				r.resp <- s.applyIncr(r, s.opts.now())
 
-			case <-ticker.C:
-				s.checkExpiredItems()
+			case r := <-s.sets:
+				r.resp <- s.applySet(r, s.opts.now())
+
+			case r := <-s.usage:
+				s.expireItems(s.opts.now())
+				i, ok := s.kval[r.key]
+				r.resp <- usageResp{count: len(s.kval), bytes: s.bytes, size: i.size, found: ok}
+
+			case r := <-s.hold:
+				r.held <- true
+				<-r.release
+
+			case <-timer.C():
+				timer.fired()
+				s.expireItems(s.opts.now())
 
 			case <-s.close:
 				return
@@ -87,103 +168,387 @@ func (s *kvStore) closeStore() {
 	s.close <- true
 }
 
-func (s *kvStore) put(item *Item, d time.Duration) error {
-	if s.set == nil {
-		log.Printf("ERROR: Init must be called first")
-		return fmt.Errorf("ERROR: Init must be called first")
-	}
+func (s *kvStore) put(ctx context.Context, item *Item, opts PutOptions) error {
+	return s.putIf(ctx, item, opts, condNone, "")
+}
+
+// putIf saves the item if the current item for its key meets the condition
+func (s *kvStore) putIf(ctx context.Context, item *Item, opts PutOptions, cond writeCond, match string) error {
 	if item == nil {
 		return fmt.Errorf("ERROR: nil item")
 	}
 	if len(item.Key) == 0 || len(item.ID) == 0 {
 		return fmt.Errorf("invalid item")
 	}
-	if d > 0 {
-		item.expiresAt = time.Now().Add(d)
+	req := setReq{
+		item:  newPutItem(item, opts, s.opts.now()),
+		cond:  cond,
+		match: match,
+		resp:  make(chan error, 1),
+		ctx:   ctx,
 	}
-	req := &setReq{
-		item: *item,
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 	select {
-	case s.set <- *req:
-	case <-time.After(3 * time.Second):
-		return fmt.Errorf("ERROR: send timeout")
+	case s.set <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
+		return fmt.Errorf("Set channel timeout")
 	}
-	return nil
+	var r error
+	select {
+	case r = <-req.resp:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return r
 }
 
-func (s *kvStore) getItem(key string) (item *Item, found bool, err error) {
-	req := &getReq{
-		key:      key,
-		resp:     make(chan Item),
-		notFound: make(chan bool),
+// newPutItem returns a copy of item with its expiry set from opts
+func newPutItem(item *Item, opts PutOptions, now time.Time) Item {
+	i := *item
+	i.idle = 0
+	if opts.IdleTimeout > 0 {
+		i.idle = opts.IdleTimeout
+	}
+	i.expireReason = NotExpired
+	i.accessedAt = now
+	i.setTTL(now, opts.TTL)
+	return i
+}
+
+func (s *kvStore) getItem(ctx context.Context, key string) (item *Item, found bool, err error) {
+	req := getReq{
+		key:  key,
+		resp: make(chan getResp, 1),
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
 	}
 	select {
-	case s.get <- *req:
-	case <-time.After(3 * time.Second):
+	case s.get <- req:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
 		return nil, false, fmt.Errorf("Get channel timeout")
 	}
+	var r getResp
 	select {
-	case i := <-req.resp:
-		return &i, true, nil
-
-	case <-req.notFound:
+	case r = <-req.resp:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+	if !r.found {
 		return nil, false, nil
 	}
+	if r.item.kind != kindPlain {
+		return nil, false, ErrWrongKind
+	}
+	return &r.item, true, nil
 }
 
-func (s *kvStore) delItem(key string) error {
+func (s *kvStore) delItem(ctx context.Context, key string) error {
+	return s.delIf(ctx, key, condNone, "")
+}
+
+// delIf deletes the item for the key if it meets the condition
+func (s *kvStore) delIf(ctx context.Context, key string, cond writeCond, match string) error {
 	if len(key) == 0 {
 		return fmt.Errorf("Invalid key")
 	}
-	req := &delReq{
-		key:  key,
-		resp: make(chan bool),
+	req := delReq{
+		key:   key,
+		cond:  cond,
+		match: match,
+		resp:  make(chan error, 1),
+		ctx:   ctx,
+	}
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 	select {
-	case s.del <- *req:
-	case <-time.After(3 * time.Second):
+	case s.del <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
 		return fmt.Errorf("Del channel timeout")
 	}
-	<-req.resp
+	var r error
+	select {
+	case r = <-req.resp:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return r
+}
+
+func (s *kvStore) changeTTL(ctx context.Context, op ttlOp, key string, d time.Duration, at time.Time) (time.Duration, bool, error) {
+	if len(key) == 0 {
+		return 0, false, fmt.Errorf("Invalid key")
+	}
+	req := ttlReq{
+		op:   op,
+		key:  key,
+		d:    d,
+		at:   at,
+		resp: make(chan ttlResp, 1),
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, false, err
+	}
+	select {
+	case s.ttl <- req:
+	case <-ctx.Done():
+		return 0, false, ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
+		return 0, false, fmt.Errorf("TTL channel timeout")
+	}
+	var r ttlResp
+	select {
+	case r = <-req.resp:
+	case <-ctx.Done():
+		return 0, false, ctx.Err()
+	}
+	return r.ttl, r.found, r.err
+}
+
+// pause stops the event loop until the returned function is called, so the
+// caller can access the store directly. It returns ctx.Err() if ctx is done
+// before the loop stops.
+func (s *kvStore) pause(ctx context.Context) (release func(), err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	req := holdReq{
+		held:    make(chan bool),
+		release: make(chan bool),
+	}
+	select {
+	case s.hold <- req:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
+		return nil, fmt.Errorf("Hold channel timeout")
+	}
+	<-req.held
+	return func() { close(req.release) }, nil
+}
+
+// dumpItems returns a copy of every item. The event loop must be paused.
+func (s *kvStore) dumpItems() []Item {
+	items := make([]Item, 0, len(s.kval))
+	for _, v := range s.kval {
+		items = append(items, v.detached())
+	}
+	return items
+}
+
+// replaceItems replaces the contents of the store with items
+func (s *kvStore) replaceItems(items []Item) {
+	s.kval = make(map[string]Item)
+	s.keys = btree.New(s.opts.degree)
+	s.forExpiry = newExpiryIndex(s.opts.degree)
+	s.bytes = 0
+	s.evict.reset()
+	for key := range s.watched {
+		s.markRemoved(key)
+	}
+	for _, i := range items {
+		s.modified(&i)
+		s.setItem(i)
+	}
+	s.evictOverflow()
+}
+
+func (s *kvStore) setItem(item Item) {
+	s.dropItem(item.Key)
+	item.size = s.estimate.itemSize(&item)
+	s.bytes += item.size
+	s.evict.touch(&item)
+	s.kval[item.Key] = item
+	s.keys.ReplaceOrInsert(keyItem(item.Key))
+	if !item.expiresAt.IsZero() {
+		s.forExpiry.add(item.Key, "", item.expiresAt)
+	}
+}
+
+// accessed records a read of the item, which restarts its idle timeout. The
+// item is updated in place and the read is not logged, so replay restarts
+// the idle timeout from the last write.
+func (s *kvStore) accessed(item *Item, now time.Time) {
+	if item.idle > 0 {
+		expiresAt := item.expiresAt
+		item.access(now)
+		if !item.expiresAt.Equal(expiresAt) {
+			s.forExpiry.remove(item.Key, "", expiresAt)
+			s.forExpiry.add(item.Key, "", item.expiresAt)
+		}
+		s.kval[item.Key] = *item
+	}
+	s.evict.touch(item)
+}
+
+// modified gives the item a new modification counter
+func (s *kvStore) modified(item *Item) {
+	s.version++
+	item.version = s.version
+}
+
+// markRemoved records that the item for the key was deleted. The version of
+// the deletion is only kept while a transaction watches the key.
+func (s *kvStore) markRemoved(key string) {
+	s.version++
+	if s.watched[key] > 0 {
+		s.removed[key] = s.version
+	}
+}
+
+// versionOf returns the modification counter of the item for the key, or the
+// counter of its deletion if there is none, so a watched key that was
+// created and deleted again in the meantime reads as changed
+func (s *kvStore) versionOf(key string) uint64 {
+	if i, ok := s.kval[key]; ok {
+		return i.version
+	}
+	return s.removed[key]
+}
+
+// watch starts watching the key for a transaction and returns its version
+func (s *kvStore) watch(key string) uint64 {
+	s.watched[key]++
+	return s.versionOf(key)
+}
+
+// unwatch stops watching the key for a transaction
+func (s *kvStore) unwatch(key string) {
+	if s.watched[key]--; s.watched[key] <= 0 {
+		delete(s.watched, key)
+		delete(s.removed, key)
+	}
+}
+
+// updateTTL reads or changes the expiry of an item
+func (s *kvStore) updateTTL(r ttlReq, now time.Time) ttlResp {
+	i, ok := s.kval[r.key]
+	if !ok || s.isExpired(i, now) {
+		return ttlResp{}
+	}
+	switch r.op {
+	case ttlGet:
+		return ttlResp{ttl: remainingTTL(i, now), found: true}
+	case ttlExpire:
+		i.deadline = now.Add(r.d)
+		i.ttl = r.d
+	case ttlExpireAt:
+		i.deadline = r.at
+		i.ttl = r.at.Sub(now)
+	case ttlPersist:
+		if i.expiresAt.IsZero() {
+			return ttlResp{ttl: NoExpiry, found: true}
+		}
+		i.deadline = time.Time{}
+		i.ttl = 0
+		i.idle = 0
+	case ttlTouch:
+		if i.expiresAt.IsZero() {
+			return ttlResp{ttl: NoExpiry, found: true}
+		}
+		i.accessedAt = now
+		if i.ttl > 0 && !i.deadline.IsZero() {
+			i.deadline = now.Add(i.ttl)
+		}
+	}
+	i.updateExpiry()
+	if err := s.wlog.append(itemRecord(opPut, i.Key, &i)); err != nil {
+		return ttlResp{err: err}
+	}
+	s.modified(&i)
+	s.setItem(i)
+
+	// a deadline in the past expires the item right away
+	s.expireItems(now)
+	return ttlResp{ttl: remainingTTL(i, now), found: true}
+}
+
+// remainingTTL returns the time left before the item expires
+func remainingTTL(i Item, now time.Time) time.Duration {
+	if i.expiresAt.IsZero() {
+		return NoExpiry
+	}
+	if d := i.expiresAt.Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// checkCond returns a *ConflictError if the item for the key does not meet
+// the condition of a conditional write
+func (s *kvStore) checkCond(key string, cond writeCond, match string, now time.Time) error {
+	if cond == condNone {
+		return nil
+	}
+	if val, ok := s.kval[key]; ok && s.isExpired(val, now) {
+		s.expireItems(now)
+	}
+	val, ok := s.kval[key]
+	switch cond {
+	case condAbsent:
+		if ok {
+			return &ConflictError{Key: key, Actual: val.ID}
+		}
+	case condMatch:
+		// hashes, sets and counters have no ID to match
+		if !ok || val.kind != kindPlain || len(val.ID) == 0 || val.ID != match {
+			return &ConflictError{Key: key, Expected: match, Actual: val.ID}
+		}
+	}
 	return nil
 }
 
-func (s *kvStore) checkExpiredItems() {
-	n := time.Now()
-	s.forExpiry.Ascend(func(a btree.Item) bool {
-		i := a.(treeItem).Value
-		d := n.Unix() - i.expiresAt.Unix()
-		key := i.Key
-		if d >= 0 {
-			//log.Printf("******* item: key: %s expired. diff: %d", key, d)
-			if s.itemExpireCb != nil {
-				go func(k string, v Item) {
-					// trigger the OnItemDidExpire callback
-					s.itemExpireCb(&v)
-				}(key, *i)
-			}
-			go s.delItem(key)
-		} else {
-			//log.Printf("item: key: %s not yet expired. diff: %d", key, d)
+func (s *kvStore) isExpired(item Item, now time.Time) bool {
+	return !item.expiresAt.IsZero() && !item.expiresAt.After(now)
+}
+
+// expireItems removes the items that are due by now
+func (s *kvStore) expireItems(now time.Time) {
+	for _, e := range s.forExpiry.due(now) {
+		key := e.key
+		i, ok := s.kval[key]
+		if !ok {
+			continue
+		}
+		if err := s.wlog.append(record{Op: opDel, Key: key}); err != nil {
+			s.opts.logf("ERROR: unable to log expiry of \"%s\": %v", key, err)
 		}
-		return true
-	})
+		delete(s.kval, key)
+		s.keys.Delete(keyItem(key))
+		s.bytes -= i.size
+		s.evict.remove(key)
+		s.markRemoved(key)
+		i.expireReason = i.expiredBy()
+		s.events.publish(EventExpire, key, &i, nil)
+	}
 }
 
 func (s *kvStore) deleteItem(key string) {
+	if _, ok := s.kval[key]; ok {
+		s.dropItem(key)
+		s.evict.remove(key)
+		s.markRemoved(key)
+	}
+}
+
+// dropItem removes the item for the key from the indexes. The evictor keeps
+// its usage, as the item is either deleted or replaced.
+func (s *kvStore) dropItem(key string) {
 	if val, ok := s.kval[key]; ok {
 		if !val.expiresAt.IsZero() {
-			ti := treeItem{
-				Key:   val.Key,
-				Value: &val,
-			}
-			s.forExpiry.Delete(ti)
+			s.forExpiry.remove(key, "", val.expiresAt)
 		}
+		s.bytes -= val.size
 	}
 	delete(s.kval, key)
-}
-
-func (s *kvStore) onItemDidExpire(cb func(item *Item)) {
-	s.itemExpireCb = cb
+	s.keys.Delete(keyItem(key))
 }
@@ -0,0 +1,412 @@
+package gostore_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tonjun/gostore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lists", func() {
+
+	var store gostore.Store
+
+	BeforeEach(func() {
+		store = gostore.NewStore()
+		store.Init()
+	})
+
+	AfterEach(func() {
+		store.Close()
+	})
+
+	idsOf := func(items []*gostore.Item) []string {
+		ids := make([]string, 0, len(items))
+		for _, i := range items {
+			ids = append(ids, i.ID)
+		}
+		return ids
+	}
+
+	pushAll := func(key string, n int) {
+		for i := 0; i < n; i++ {
+			store.ListPush(key, &gostore.Item{ID: fmt.Sprintf("%02d", i), Value: i})
+		}
+	}
+
+	It("ListRange() should page through the list", func() {
+		pushAll("l1", 5)
+
+		items, next, err := store.ListRange("l1", "", 2)
+		Expect(err).To(BeNil())
+		Expect(idsOf(items)).To(Equal([]string{"00", "01"}))
+		Expect(next).To(Equal("02"))
+
+		items, next, _ = store.ListRange("l1", next, 2)
+		Expect(idsOf(items)).To(Equal([]string{"02", "03"}))
+
+		items, next, _ = store.ListRange("l1", next, 2)
+		Expect(idsOf(items)).To(Equal([]string{"04"}))
+		Expect(next).To(BeEmpty())
+	})
+
+	It("ListRangeReverse() should page through the list from the end", func() {
+		pushAll("l1", 5)
+
+		items, next, err := store.ListRangeReverse("l1", "", 3)
+		Expect(err).To(BeNil())
+		Expect(idsOf(items)).To(Equal([]string{"04", "03", "02"}))
+		Expect(next).To(Equal("01"))
+
+		items, next, _ = store.ListRangeReverse("l1", next, 3)
+		Expect(idsOf(items)).To(Equal([]string{"01", "00"}))
+		Expect(next).To(BeEmpty())
+	})
+
+	It("ListRange() of a missing list should be empty", func() {
+		items, next, err := store.ListRange("missing", "", 10)
+		Expect(err).To(BeNil())
+		Expect(items).To(BeEmpty())
+		Expect(next).To(BeEmpty())
+	})
+
+	It("ListLen(), ListContains() and ListGetItem() should read single members", func() {
+		pushAll("l1", 3)
+		store.ListPushWithTTL("l1", &gostore.Item{ID: "tmp", Value: "tmp"}, 10*time.Millisecond)
+
+		Expect(store.ListLen("l1")).To(Equal(4))
+		Expect(store.ListContains("l1", "01")).To(BeTrue())
+		Expect(store.ListContains("l1", "99")).To(BeFalse())
+
+		item, found, err := store.ListGetItem("l1", "02")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(item.Value).To(Equal(2))
+
+		time.Sleep(20 * time.Millisecond)
+		Expect(store.ListLen("l1")).To(Equal(3))
+		Expect(store.ListContains("l1", "tmp")).To(BeFalse())
+		Expect(store.ListLen("missing")).To(Equal(0))
+	})
+
+	valuesOf := func(items []*gostore.Item) []interface{} {
+		values := make([]interface{}, 0, len(items))
+		for _, i := range items {
+			values = append(values, i.Value)
+		}
+		return values
+	}
+
+	It("LPush() and RPush() should keep the insertion order and duplicates", func() {
+		Expect(store.RPush("q", &gostore.Item{Value: "b"}, &gostore.Item{Value: "c"})).To(Equal(2))
+		Expect(store.LPush("q", &gostore.Item{Value: "a"}, &gostore.Item{Value: "z"})).To(Equal(4))
+		Expect(store.RPush("q", &gostore.Item{Value: "c"})).To(Equal(5))
+
+		items, err := store.LRange("q", 0, -1)
+		Expect(err).To(BeNil())
+		Expect(valuesOf(items)).To(Equal([]interface{}{"z", "a", "b", "c", "c"}))
+		Expect(store.LLen("q")).To(Equal(5))
+	})
+
+	It("LPop() and RPop() should remove members from both ends", func() {
+		for i := 0; i < 20; i++ {
+			store.RPush("q", &gostore.Item{Value: i})
+		}
+
+		item, found, err := store.LPop("q")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(item.Value).To(Equal(0))
+
+		item, _, _ = store.RPop("q")
+		Expect(item.Value).To(Equal(19))
+
+		for i := 1; i < 19; i++ {
+			item, _, _ = store.LPop("q")
+			Expect(item.Value).To(Equal(i))
+		}
+		_, found, err = store.LPop("q")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeFalse())
+		Expect(store.LLen("q")).To(Equal(0))
+	})
+
+	It("LIndex() should accept negative indexes", func() {
+		store.RPush("q", &gostore.Item{Value: "a"}, &gostore.Item{Value: "b"}, &gostore.Item{Value: "c"})
+
+		item, found, _ := store.LIndex("q", 1)
+		Expect(found).To(BeTrue())
+		Expect(item.Value).To(Equal("b"))
+
+		item, _, _ = store.LIndex("q", -1)
+		Expect(item.Value).To(Equal("c"))
+
+		_, found, _ = store.LIndex("q", 3)
+		Expect(found).To(BeFalse())
+	})
+
+	It("LTrim() should keep the members in the range", func() {
+		for i := 0; i < 10; i++ {
+			store.RPush("q", &gostore.Item{Value: i})
+		}
+		Expect(store.LTrim("q", 2, -3)).To(BeNil())
+		items, _ := store.LRange("q", 0, -1)
+		Expect(valuesOf(items)).To(Equal([]interface{}{2, 3, 4, 5, 6, 7}))
+
+		Expect(store.LTrim("q", 5, 2)).To(BeNil())
+		Expect(store.LLen("q")).To(Equal(0))
+	})
+
+	It("LInsert() should insert relative to the pivot", func() {
+		store.RPush("q", &gostore.Item{ID: "a", Value: "a"}, &gostore.Item{ID: "c", Value: "c"})
+
+		Expect(store.LInsert("q", gostore.InsertBefore, "c", &gostore.Item{ID: "b", Value: "b"})).To(Equal(3))
+		Expect(store.LInsert("q", gostore.InsertAfter, "c", &gostore.Item{ID: "d", Value: "d"})).To(Equal(4))
+		Expect(store.LInsert("q", gostore.InsertAfter, "x", &gostore.Item{Value: "x"})).To(Equal(-1))
+		Expect(store.LInsert("missing", gostore.InsertAfter, "x", &gostore.Item{Value: "x"})).To(Equal(0))
+
+		items, _ := store.LRange("q", 0, -1)
+		Expect(valuesOf(items)).To(Equal([]interface{}{"a", "b", "c", "d"}))
+	})
+
+	It("Both kinds of list should not share a key", func() {
+		store.ListPush("set", &gostore.Item{ID: "a", Value: "a"})
+		store.RPush("seq", &gostore.Item{Value: "a"})
+
+		_, err := store.RPush("set", &gostore.Item{Value: "b"})
+		Expect(err).To(Equal(gostore.ErrWrongKind))
+		Expect(store.ListPush("seq", &gostore.Item{ID: "b", Value: "b"})).To(Equal(gostore.ErrWrongKind))
+		Expect(store.ListDel("seq", &gostore.Item{ID: "a"})).To(Equal(gostore.ErrWrongKind))
+		Expect(store.Apply(gostore.NewBatch().ListDel("seq", &gostore.Item{ID: "a"}))).To(Equal(gostore.ErrWrongKind))
+
+		items, found, _ := store.ListGet("seq")
+		Expect(found).To(BeFalse())
+		Expect(items).To(BeEmpty())
+		n, _ := store.LLen("seq")
+		Expect(n).To(Equal(1))
+	})
+
+	It("OnListDidChange() should report the changes to insertion ordered lists", func() {
+		lists := make(chan []string, 10)
+		store.OnListDidChange(func(key string, items []*gostore.Item) {
+			var ids []string
+			for _, i := range items {
+				ids = append(ids, i.ID)
+			}
+			lists <- ids
+		})
+
+		store.RPush("seq", &gostore.Item{ID: "a"}, &gostore.Item{ID: "b"})
+		Eventually(lists).Should(Receive(Equal([]string{"a", "b"})))
+		store.LInsert("seq", gostore.InsertAfter, "a", &gostore.Item{ID: "c"})
+		Eventually(lists).Should(Receive(Equal([]string{"a", "c", "b"})))
+		store.LTrim("seq", 1, -1)
+		Eventually(lists).Should(Receive(Equal([]string{"c", "b"})))
+		store.LPop("seq")
+		Eventually(lists).Should(Receive(Equal([]string{"b"})))
+		store.RPop("seq")
+		Eventually(lists).Should(Receive(BeEmpty()))
+	})
+
+	It("OnListDiff() should report the changes to insertion ordered lists", func() {
+		diffs := make(chan gostore.ListDiff, 10)
+		store.OnListDiff(func(diff gostore.ListDiff) {
+			diffs <- diff
+		})
+		ids := func(items []*gostore.Item) []string {
+			var ids []string
+			for _, i := range items {
+				ids = append(ids, i.ID)
+			}
+			return ids
+		}
+		expect := func(op gostore.ListOp, added, removed []string) {
+			var d gostore.ListDiff
+			Eventually(diffs).Should(Receive(&d))
+			Expect(d.Key).To(Equal("seq"))
+			Expect(d.Op).To(Equal(op))
+			Expect(ids(d.Added)).To(Equal(added))
+			Expect(ids(d.Removed)).To(Equal(removed))
+		}
+
+		store.RPush("seq", &gostore.Item{ID: "a"}, &gostore.Item{ID: "b"})
+		expect(gostore.ListOpRPush, []string{"a", "b"}, nil)
+		store.LPush("seq", &gostore.Item{ID: "z"})
+		expect(gostore.ListOpLPush, []string{"z"}, nil)
+		store.LInsert("seq", gostore.InsertAfter, "a", &gostore.Item{ID: "c"})
+		expect(gostore.ListOpInsert, []string{"c"}, nil)
+		store.LTrim("seq", 1, -2)
+		expect(gostore.ListOpTrim, nil, []string{"z", "b"})
+		store.LPop("seq")
+		expect(gostore.ListOpLPop, nil, []string{"a"})
+		store.RPop("seq")
+		expect(gostore.ListOpRPop, nil, []string{"c"})
+	})
+
+	It("LInsert() should log only the inserted member", func() {
+		dir, err := ioutil.TempDir("", "gostore")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "store.log")
+
+		logged := gostore.NewStoreWithConfig(gostore.Config{LogPath: path, LogSync: gostore.SyncAlways})
+		logged.Init()
+		defer logged.Close()
+		for i := 0; i < 200; i++ {
+			logged.RPush("q", &gostore.Item{ID: fmt.Sprint(i), Value: i})
+		}
+		before, err := os.Stat(path)
+		Expect(err).To(BeNil())
+		Expect(logged.LInsert("q", gostore.InsertBefore, "100", &gostore.Item{ID: "x", Value: "x"})).To(Equal(201))
+		after, err := os.Stat(path)
+		Expect(err).To(BeNil())
+		Expect(after.Size() - before.Size()).To(BeNumerically("<", 512))
+	})
+
+	It("Insertion ordered lists should be replayed from the log and restored from snapshots", func() {
+		dir, err := ioutil.TempDir("", "gostore")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		cfg := gostore.Config{LogPath: filepath.Join(dir, "store.log"), LogSync: gostore.SyncAlways}
+
+		logged := gostore.NewStoreWithConfig(cfg)
+		logged.Init()
+		for i := 0; i < 6; i++ {
+			logged.RPush("q", &gostore.Item{ID: fmt.Sprint(i), Value: i})
+		}
+		logged.LPush("q", &gostore.Item{ID: "-1", Value: -1})
+		logged.LPop("q")
+		logged.RPop("q")
+		logged.LTrim("q", 1, -1)
+		logged.LInsert("q", gostore.InsertAfter, "2", &gostore.Item{ID: "x", Value: "x"})
+		logged.LInsert("q", gostore.InsertBefore, "1", &gostore.Item{ID: "y", Value: "y"})
+		logged.RPush("q", &gostore.Item{ID: "9", Value: 9})
+		logged.LInsert("q", gostore.InsertAfter, "9", &gostore.Item{ID: "z", Value: "z"})
+		logged.Close()
+
+		expected := []interface{}{"y", 1, 2, "x", 3, 4, 9, "z"}
+		logged = gostore.NewStoreWithConfig(cfg)
+		logged.Init()
+		items, _ := logged.LRange("q", 0, -1)
+		Expect(valuesOf(items)).To(Equal(expected))
+
+		var buf bytes.Buffer
+		Expect(logged.Snapshot(&buf)).To(BeNil())
+		logged.Close()
+
+		Expect(store.Restore(&buf)).To(BeNil())
+		items, _ = store.LRange("q", 0, -1)
+		Expect(valuesOf(items)).To(Equal(expected))
+	})
+
+	It("BlockingPop() should return a member that is already there", func() {
+		store.RPush("q2", &gostore.Item{Value: "job"})
+
+		key, item, err := store.BlockingPop(context.Background(), []string{"q1", "q2"})
+		Expect(err).To(BeNil())
+		Expect(key).To(Equal("q2"))
+		Expect(item.Value).To(Equal("job"))
+	})
+
+	It("BlockingPop() should wait until a member is pushed", func() {
+		type popped struct {
+			key  string
+			item *gostore.Item
+		}
+		results := make(chan popped, 1)
+		go func() {
+			defer GinkgoRecover()
+			key, item, err := store.BlockingPop(context.Background(), []string{"q1", "jobs"})
+			Expect(err).To(BeNil())
+			results <- popped{key, item}
+		}()
+		Consistently(results).ShouldNot(Receive())
+
+		store.ListPush("jobs", &gostore.Item{ID: "b", Value: "b data"})
+		var p popped
+		Eventually(results).Should(Receive(&p))
+		Expect(p.key).To(Equal("jobs"))
+		Expect(p.item.ID).To(Equal("b"))
+		Expect(store.ListLen("jobs")).To(Equal(0))
+	})
+
+	It("BlockingPop() should serve waiters in the order they started waiting", func() {
+		order := make(chan int, 3)
+		for i := 0; i < 3; i++ {
+			go func(i int) {
+				defer GinkgoRecover()
+				_, item, err := store.BlockingPop(context.Background(), []string{"q"})
+				Expect(err).To(BeNil())
+				Expect(item.Value).To(Equal(i))
+				order <- i
+			}(i)
+			// wait for the waiter to be queued before starting the next one
+			time.Sleep(20 * time.Millisecond)
+		}
+		for i := 0; i < 3; i++ {
+			store.RPush("q", &gostore.Item{Value: i})
+			Eventually(order).Should(Receive(Equal(i)))
+		}
+	})
+
+	It("BlockingPop() should hand every member to a single waiter", func() {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		seen := make(map[interface{}]int)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				_, item, err := store.BlockingPop(context.Background(), []string{"q"})
+				Expect(err).To(BeNil())
+				mu.Lock()
+				seen[item.Value]++
+				mu.Unlock()
+			}()
+		}
+		for i := 0; i < 10; i++ {
+			store.RPush("q", &gostore.Item{Value: i})
+		}
+		wg.Wait()
+		Expect(seen).To(HaveLen(10))
+	})
+
+	It("BlockingPop() should not receive a member that has expired", func() {
+		popped := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			_, _, err := store.BlockingPop(ctx, []string{"l1"})
+			popped <- err
+		}()
+		// let the request start waiting
+		time.Sleep(20 * time.Millisecond)
+
+		store.ListPushWithTTL("l1", &gostore.Item{ID: "a", Value: "a data"}, time.Nanosecond)
+		Eventually(popped).Should(Receive(Equal(context.DeadlineExceeded)))
+	})
+
+	It("BlockingPop() should return when the context is done", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, item, err := store.BlockingPop(ctx, []string{"q"})
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		Expect(item).To(BeNil())
+
+		// the cancelled request must not take later members
+		store.RPush("q", &gostore.Item{Value: "job"})
+		Expect(store.LLen("q")).To(Equal(1))
+	})
+
+})
@@ -1,26 +1,59 @@
 package gostore
 
 import (
+	"context"
 	"fmt"
-	//"log"
 	"time"
 
 	"github.com/google/btree"
 )
 
 type listStore struct {
-	lpush        chan listPushReq
-	lget         chan listGetReq
-	ldel         chan listDelReq
-	close        chan bool
-	ktree        map[string]*btree.BTree
-	listChangeCb func(string, []*Item)
+	lpush     chan listPushReq
+	lget      chan listGetReq
+	ldel      chan listDelReq
+	lrange    chan listRangeReq
+	litem     chan listItemReq
+	llen      chan listLenReq
+	lseq      chan seqReq
+	lpop      chan *popReq
+	lcancel   chan cancelPopReq
+	lzset     chan zsetReq
+	lusage    chan usageReq
+	hold      chan holdReq
+	close     chan bool
+	done      chan bool // closed once the event loop has returned
+	ktree     map[string]*btree.BTree
+	seqs      map[string]*seqList  // insertion ordered lists
+	zsets     map[string]*zset     // sorted sets
+	waiters   map[string][]*popReq // BlockingPop requests waiting on each list
+	forExpiry *expiryIndex         // list members with an expiry, ordered by deadline
+	versions  map[string]uint64    // modification counters of the lists
+	version   uint64               // incremented on every change to a list
+	watched   map[string]int       // the number of transactions watching each key
+	removed   map[string]uint64    // the versions of the watched lists that were deleted
+	sizes     map[string]int64     // approximate memory used by each list
+	bytes     int64                // approximate memory used by all lists
+	estimate  SizeEstimator
+	wlog      *appendLog // optional append-only log of applied mutations
+	events    *eventHub  // optional hub for keyspace events
+	opts      *settings
 }
 
-func newListStore() *listStore {
+func newListStore(opts *settings) *listStore {
 	return &listStore{
-		close: make(chan bool),
-		ktree: make(map[string]*btree.BTree),
+		close:     make(chan bool),
+		done:      make(chan bool),
+		ktree:     make(map[string]*btree.BTree),
+		seqs:      make(map[string]*seqList),
+		zsets:     make(map[string]*zset),
+		waiters:   make(map[string][]*popReq),
+		forExpiry: newExpiryIndex(opts.degree),
+		versions:  make(map[string]uint64),
+		watched:   make(map[string]int),
+		removed:   make(map[string]uint64),
+		sizes:     make(map[string]int64),
+		opts:      opts,
 	}
 }
 
@@ -28,54 +61,146 @@ func (s *listStore) init() {
 	s.lpush = make(chan listPushReq)
 	s.lget = make(chan listGetReq)
 	s.ldel = make(chan listDelReq)
+	s.lrange = make(chan listRangeReq)
+	s.litem = make(chan listItemReq)
+	s.llen = make(chan listLenReq)
+	s.lseq = make(chan seqReq)
+	s.lpop = make(chan *popReq)
+	s.lcancel = make(chan cancelPopReq)
+	s.lzset = make(chan zsetReq)
+	s.lusage = make(chan usageReq)
+	s.hold = make(chan holdReq)
 	go func() {
+		timer := newExpiryTimer(s.opts)
+
 		defer func() {
 			//log.Printf("listStore closed")
+			timer.stop()
+			close(s.done)
 		}()
 
 		for {
+			// wake up exactly when the next list member is due
+			timer.schedule(s.forExpiry)
+
 			select {
 
 			case r := <-s.lpush:
-				ti := treeItem{
-					Key:   r.item.ID,
-					Value: &r.item,
+				err := r.ctx.Err()
+				if err == nil {
+					err = s.checkKind(r.key, kindIDList)
 				}
-				l := s.getTree(r.key).Len()
-				s.getTree(r.key).ReplaceOrInsert(ti)
+				if err == nil {
+					err = s.wlog.append(itemRecord(opListPush, r.key, &r.item))
+				}
+				if err == nil {
+					old := s.pushItem(r.key, r.item)
+					s.events.publish(EventListPush, r.key, old, &r.item)
+					if old != nil {
+						s.triggerListDiff(r.key, ListOpPush, []Item{r.item}, []Item{*old})
+					} else {
+						s.triggerListDiff(r.key, ListOpPush, []Item{r.item}, nil)
+					}
 
-				// if tree len changed, trigger callback
-				if l != s.getTree(r.key).Len() {
-					s.triggerListDidChange(r.key)
+					// if tree len changed, trigger callback
+					if old == nil {
+						s.triggerListDidChange(r.key)
+					}
 				}
+				r.resp <- err
+				s.serveWaiters(r.key)
 
 			case r := <-s.lget:
+				s.expireItems(s.opts.now())
 				if _, ok := s.ktree[r.key]; !ok {
-					r.notFound <- true
+					r.resp <- listGetResp{}
 				} else {
 					items := make([]*Item, 0)
 					s.getTree(r.key).Ascend(func(a btree.Item) bool {
 						items = append(items, a.(treeItem).Value)
 						return true
 					})
-					r.resp <- items
+					r.resp <- listGetResp{items: items, found: true}
 				}
 
 			case r := <-s.ldel:
 				ti := treeItem{
-					Key:   r.item.ID,
-					Value: &r.item,
+					Key: r.item.ID,
 				}
-				l := s.getTree(r.key).Len()
-				s.getTree(r.key).Delete(ti)
-				r.resp <- true
+				err := r.ctx.Err()
+				if err == nil {
+					err = s.checkKind(r.key, kindIDList)
+				}
+				removed := false
+				if tree, ok := s.ktree[r.key]; ok && err == nil && tree.Has(ti) {
+					err = s.wlog.append(record{Op: opListDel, Key: r.key, ID: r.item.ID})
+					if err == nil {
+						var old Item
+						old, removed = s.removeItem(r.key, r.item.ID)
+						s.events.publish(EventListDel, r.key, &old, nil)
+						s.triggerListDiff(r.key, ListOpDel, nil, []Item{old})
+					}
+				}
+				r.resp <- err
 
 				// if tree len changed, trigger callback
-				if l != s.getTree(r.key).Len() {
+				if removed {
 					s.triggerListDidChange(r.key)
 				}
 
+			case r := <-s.lrange:
+				s.expireItems(s.opts.now())
+				r.resp <- s.rangeItems(r)
+
+			case r := <-s.litem:
+				s.expireItems(s.opts.now())
+				var resp listItemResp
+				if tree, ok := s.ktree[r.key]; ok {
+					if a := tree.Get(treeItem{Key: r.id}); a != nil {
+						resp.item = *a.(treeItem).Value
+						resp.found = true
+					}
+				}
+				r.resp <- resp
+
+			case r := <-s.llen:
+				s.expireItems(s.opts.now())
+				n := 0
+				if tree, ok := s.ktree[r.key]; ok {
+					n = tree.Len()
+				}
+				r.resp <- n
+
+			case r := <-s.lseq:
+				r.resp <- s.applySeq(r)
+				s.serveWaiters(r.key)
+
+			case r := <-s.lzset:
+				r.resp <- s.applyZSet(r)
+
+			case r := <-s.lpop:
+				s.expireItems(s.opts.now())
+				s.waitPop(r)
+
+			case r := <-s.lcancel:
+				s.removeWaiter(r.req)
+				r.resp <- true
+
+			case r := <-s.lusage:
+				s.expireItems(s.opts.now())
+				size, ok := s.sizes[r.key]
+				r.resp <- usageResp{count: len(s.sizes), bytes: s.bytes, size: size, found: ok}
+
+			case r := <-s.hold:
+				r.held <- true
+				<-r.release
+
+			case <-timer.C():
+				timer.fired()
+				s.expireItems(s.opts.now())
+
 			case <-s.close:
+				s.closeWaiters()
 				return
 
 			}
@@ -87,7 +212,7 @@ func (s *listStore) closeStore() {
 	s.close <- true
 }
 
-func (s *listStore) listPush(key string, value *Item) error {
+func (s *listStore) listPush(ctx context.Context, key string, value *Item, d time.Duration) error {
 	if value == nil {
 		return fmt.Errorf("ERROR: nil value")
 	}
@@ -96,17 +221,39 @@ func (s *listStore) listPush(key string, value *Item) error {
 	}
 	req := listPushReq{
 		key:  key,
-		item: *value,
+		item: newListItem(value, d, s.opts.now()),
+		resp: make(chan error, 1),
+		ctx:  ctx,
+	}
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 	select {
 	case s.lpush <- req:
-	case <-time.After(3 * time.Second):
-		return fmt.Errorf("ERROR: channel timeout")
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
+		return fmt.Errorf("Push channel timeout")
 	}
-	return nil
+	var r error
+	select {
+	case r = <-req.resp:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return r
 }
 
-func (s *listStore) listDel(key string, value *Item) error {
+// newListItem returns a copy of value set to expire after the duration d
+func newListItem(value *Item, d time.Duration, now time.Time) Item {
+	i := *value
+	i.idle = 0
+	i.expireReason = NotExpired
+	i.setTTL(now, d)
+	return i
+}
+
+func (s *listStore) listDel(ctx context.Context, key string, value *Item) error {
 	if value == nil {
 		return fmt.Errorf("ERROR: nil value")
 	}
@@ -116,42 +263,429 @@ func (s *listStore) listDel(key string, value *Item) error {
 	req := listDelReq{
 		key:  key,
 		item: *value,
-		resp: make(chan bool),
+		resp: make(chan error, 1),
+		ctx:  ctx,
+	}
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 	select {
 	case s.ldel <- req:
-	case <-time.After(3 * time.Second):
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
 		return fmt.Errorf("Del channel timeout")
 	}
-	<-req.resp
-	return nil
+	var r error
+	select {
+	case r = <-req.resp:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return r
 }
 
-func (s *listStore) listGet(key string) ([]*Item, bool, error) {
-	var items []*Item
-
+func (s *listStore) listGet(ctx context.Context, key string) ([]*Item, bool, error) {
 	req := listGetReq{
-		key:      key,
-		resp:     make(chan []*Item),
-		notFound: make(chan bool),
+		key:  key,
+		resp: make(chan listGetResp, 1),
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
 	}
 	select {
 	case s.lget <- req:
-	case <-time.After(3 * time.Second):
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
 		return nil, false, fmt.Errorf("Get channel timeout")
 	}
+	var r listGetResp
 	select {
-	case items = <-req.resp:
-		return items, true, nil
-	case <-req.notFound:
+	case r = <-req.resp:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+	if !r.found {
 		return make([]*Item, 0), false, nil
 	}
+	return r.items, true, nil
+}
+
+func (s *listStore) listRange(ctx context.Context, key, from string, limit int, reverse bool) ([]*Item, string, error) {
+	if len(key) == 0 {
+		return nil, "", fmt.Errorf("invalid input")
+	}
+	req := listRangeReq{
+		key:     key,
+		from:    from,
+		limit:   limit,
+		reverse: reverse,
+		resp:    make(chan listRangeResp, 1),
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	select {
+	case s.lrange <- req:
+	case <-ctx.Done():
+		return nil, "", ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
+		return nil, "", fmt.Errorf("Range channel timeout")
+	}
+	var r listRangeResp
+	select {
+	case r = <-req.resp:
+	case <-ctx.Done():
+		return nil, "", ctx.Err()
+	}
+	items := make([]*Item, len(r.items))
+	for i := range r.items {
+		items[i] = &r.items[i]
+	}
+	return items, r.next, nil
+}
+
+func (s *listStore) listGetItem(ctx context.Context, key, id string) (*Item, bool, error) {
+	if len(key) == 0 || len(id) == 0 {
+		return nil, false, fmt.Errorf("invalid input")
+	}
+	req := listItemReq{
+		key:  key,
+		id:   id,
+		resp: make(chan listItemResp, 1),
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	select {
+	case s.litem <- req:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
+		return nil, false, fmt.Errorf("Get channel timeout")
+	}
+	var r listItemResp
+	select {
+	case r = <-req.resp:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+	if !r.found {
+		return nil, false, nil
+	}
+	return &r.item, true, nil
+}
+
+func (s *listStore) listLen(ctx context.Context, key string) (int, error) {
+	req := listLenReq{
+		key:  key,
+		resp: make(chan int, 1),
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	select {
+	case s.llen <- req:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
+		return 0, fmt.Errorf("Len channel timeout")
+	}
+	var r int
+	select {
+	case r = <-req.resp:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	return r, nil
+}
+
+// rangeItems returns up to limit members of the list starting at the member
+// with the ID r.from and the ID of the first member of the next page
+func (s *listStore) rangeItems(r listRangeReq) listRangeResp {
+	var resp listRangeResp
+	tree, ok := s.ktree[r.key]
+	if !ok {
+		return resp
+	}
+	limit := r.limit
+	if limit <= 0 {
+		limit = DefaultScanLimit
+	}
+	visit := func(a btree.Item) bool {
+		i := a.(treeItem).Value
+		if len(resp.items) == limit {
+			resp.next = i.ID
+			return false
+		}
+		resp.items = append(resp.items, *i)
+		return true
+	}
+	switch {
+	case r.reverse && len(r.from) == 0:
+		tree.Descend(visit)
+	case r.reverse:
+		tree.DescendLessOrEqual(treeItem{Key: r.from}, visit)
+	default:
+		tree.AscendGreaterOrEqual(treeItem{Key: r.from}, visit)
+	}
+	return resp
+}
+
+// listKind is the kind of list held by a key
+type listKind int
+
+const (
+	kindNone   listKind = iota
+	kindIDList          // ordered by Item.ID
+	kindSeq             // insertion ordered
+	kindZSet            // sorted set
+)
+
+// kindOf returns the kind of list held by the key
+func (s *listStore) kindOf(key string) listKind {
+	if tree, ok := s.ktree[key]; ok && tree.Len() > 0 {
+		return kindIDList
+	}
+	if _, ok := s.seqs[key]; ok {
+		return kindSeq
+	}
+	if _, ok := s.zsets[key]; ok {
+		return kindZSet
+	}
+	return kindNone
+}
+
+// checkKind returns ErrWrongKind if the key holds a list of another kind
+func (s *listStore) checkKind(key string, kind listKind) error {
+	if k := s.kindOf(key); k != kindNone && k != kind {
+		return ErrWrongKind
+	}
+	return nil
+}
+
+// pause stops the event loop until the returned function is called, so the
+// caller can access the store directly. It returns ctx.Err() if ctx is done
+// before the loop stops.
+func (s *listStore) pause(ctx context.Context) (release func(), err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	req := holdReq{
+		held:    make(chan bool),
+		release: make(chan bool),
+	}
+	select {
+	case s.hold <- req:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
+		return nil, fmt.Errorf("Hold channel timeout")
+	}
+	<-req.held
+	return func() { close(req.release) }, nil
+}
+
+// dumpLists returns a copy of every list. The event loop must be paused.
+func (s *listStore) dumpLists() listContents {
+	lists := make(map[string][]Item, len(s.ktree))
+	for key, tree := range s.ktree {
+		items := make([]Item, 0, tree.Len())
+		tree.Ascend(func(a btree.Item) bool {
+			items = append(items, *a.(treeItem).Value)
+			return true
+		})
+		lists[key] = items
+	}
+	seqs := make(map[string]seqDump, len(s.seqs))
+	for key, l := range s.seqs {
+		seqs[key] = seqDump{Head: l.head, Items: l.slice(0, l.len()-1)}
+	}
+	zsets := make(map[string][]zsetMember, len(s.zsets))
+	for key, z := range s.zsets {
+		zsets[key] = z.all()
+	}
+	return listContents{lists: lists, seqs: seqs, zsets: zsets}
+}
+
+// replaceLists replaces the contents of the store
+func (s *listStore) replaceLists(lc listContents) {
+	s.ktree = make(map[string]*btree.BTree)
+	s.seqs = make(map[string]*seqList)
+	s.zsets = make(map[string]*zset)
+	s.forExpiry = newExpiryIndex(s.opts.degree)
+	s.versions = make(map[string]uint64)
+	s.sizes = make(map[string]int64)
+	s.bytes = 0
+	for key, items := range lc.lists {
+		for _, i := range items {
+			s.pushItem(key, i)
+		}
+	}
+	for key, d := range lc.seqs {
+		if len(d.Items) > 0 {
+			s.seqs[key] = newSeqList(d.Head, d.Items)
+			s.modified(key)
+			var size int64
+			for n := range d.Items {
+				size += s.estimate.itemSize(&d.Items[n])
+			}
+			s.resize(key, size)
+		}
+	}
+	for key, members := range lc.zsets {
+		if len(members) > 0 {
+			z := newZSet(s.opts.degree)
+			var size int64
+			for n, m := range members {
+				z.add(m)
+				size += s.memberSize(&members[n])
+			}
+			s.zsets[key] = z
+			s.modified(key)
+			s.resize(key, size)
+		}
+	}
+	for key := range s.watched {
+		s.modified(key)
+	}
+}
+
+// pushItem adds or replaces the item in the list and returns the member it
+// replaced, if any
+func (s *listStore) pushItem(key string, item Item) *Item {
+	tree := s.getTree(key)
+	old := tree.ReplaceOrInsert(treeItem{
+		Key:   item.ID,
+		Value: &item,
+	})
+	if old != nil {
+		if o := old.(treeItem).Value; !o.expiresAt.IsZero() {
+			s.forExpiry.remove(key, o.ID, o.expiresAt)
+		}
+	}
+	if !item.expiresAt.IsZero() {
+		s.forExpiry.add(key, item.ID, item.expiresAt)
+	}
+	s.modified(key)
+	if old == nil {
+		s.resize(key, s.estimate.itemSize(&item))
+		return nil
+	}
+	o := old.(treeItem).Value
+	s.resize(key, s.estimate.itemSize(&item)-s.estimate.itemSize(o))
+	return o
+}
+
+// removeItem removes the item with the id from the list
+func (s *listStore) removeItem(key, id string) (Item, bool) {
+	tree, ok := s.ktree[key]
+	if !ok {
+		return Item{}, false
+	}
+	old := tree.Delete(treeItem{Key: id})
+	if old == nil {
+		return Item{}, false
+	}
+	if tree.Len() == 0 {
+		delete(s.ktree, key)
+	}
+	o := old.(treeItem).Value
+	if !o.expiresAt.IsZero() {
+		s.forExpiry.remove(key, o.ID, o.expiresAt)
+	}
+	s.modified(key)
+	s.resize(key, -s.estimate.itemSize(o))
+	return *o, true
+}
+
+// modified gives the list a new modification counter. The counter of a list
+// that is gone is only kept while a transaction watches the key.
+func (s *listStore) modified(key string) {
+	s.version++
+	if s.kindOf(key) != kindNone {
+		s.versions[key] = s.version
+		return
+	}
+	delete(s.versions, key)
+	if s.watched[key] > 0 {
+		s.removed[key] = s.version
+	}
+}
+
+// versionOf returns the modification counter of the list for the key, or the
+// counter of its deletion if there is none
+func (s *listStore) versionOf(key string) uint64 {
+	if v, ok := s.versions[key]; ok {
+		return v
+	}
+	return s.removed[key]
+}
+
+// watch starts watching the list for a transaction and returns its version
+func (s *listStore) watch(key string) uint64 {
+	s.watched[key]++
+	return s.versionOf(key)
+}
+
+// unwatch stops watching the list for a transaction
+func (s *listStore) unwatch(key string) {
+	if s.watched[key]--; s.watched[key] <= 0 {
+		delete(s.watched, key)
+		delete(s.removed, key)
+	}
+}
+
+// resize adds delta to the approximate memory used by the list for the key,
+// once the list has been changed
+func (s *listStore) resize(key string, delta int64) {
+	size, ok := s.sizes[key]
+	if !ok {
+		size = listOverhead + int64(len(key))
+		s.bytes += size
+	}
+	size += delta
+	s.bytes += delta
+	if s.kindOf(key) == kindNone {
+		s.bytes -= size
+		delete(s.sizes, key)
+		return
+	}
+	s.sizes[key] = size
+}
+
+// expireItems removes the list members that are due by now
+func (s *listStore) expireItems(now time.Time) {
+	for _, e := range s.forExpiry.due(now) {
+		tree, ok := s.ktree[e.key]
+		if !ok {
+			continue
+		}
+		old := tree.Delete(treeItem{Key: e.id})
+		if old == nil {
+			continue
+		}
+		if tree.Len() == 0 {
+			delete(s.ktree, e.key)
+		}
+		s.modified(e.key)
+		if err := s.wlog.append(record{Op: opListDel, Key: e.key, ID: e.id}); err != nil {
+			s.opts.logf("ERROR: unable to log expiry of \"%s\" in \"%s\": %v", e.id, e.key, err)
+		}
+		v := *old.(treeItem).Value
+		s.resize(e.key, -s.estimate.itemSize(&v))
+		v.expireReason = v.expiredBy()
+		s.events.publish(EventListExpire, e.key, &v, nil)
+		s.triggerListDiff(e.key, ListOpExpire, nil, []Item{v})
+		s.triggerListDidChange(e.key)
+	}
 }
 
 func (s *listStore) getTree(key string) *btree.BTree {
 	var tree *btree.BTree
 	if t, ok := s.ktree[key]; !ok {
-		tree = btree.New(32)
+		tree = btree.New(s.opts.degree)
 		s.ktree[key] = tree
 	} else {
 		tree = t
@@ -159,18 +693,44 @@ func (s *listStore) getTree(key string) *btree.BTree {
 	return tree
 }
 
-func (s *listStore) onListDidChange(cb func(string, []*Item)) {
-	s.listChangeCb = cb
+// triggerListDidChange publishes the current contents of the list to the
+// OnListDidChange callbacks
+func (s *listStore) triggerListDidChange(key string) {
+	if !s.events.wants(eventListChange, key) {
+		return
+	}
+	//log.Printf("triggerListDidChange: key: \"%s\"", key)
+	items := make([]*Item, 0)
+	if l, ok := s.seqs[key]; ok {
+		for _, i := range l.slice(0, l.len()-1) {
+			i := i
+			items = append(items, &i)
+		}
+	} else if tree, ok := s.ktree[key]; ok {
+		tree.Ascend(func(a btree.Item) bool {
+			i := *a.(treeItem).Value
+			items = append(items, &i)
+			return true
+		})
+	}
+	s.events.publishListChange(key, items)
 }
 
-func (s *listStore) triggerListDidChange(key string) {
-	if s.listChangeCb != nil {
-		//log.Printf("triggerListDidChange: key: \"%s\"", key)
-		go func() {
-			items, found, _ := s.listGet(key)
-			if found {
-				s.listChangeCb(key, items)
-			}
-		}()
+// triggerListDiff publishes the members added to and removed from the list
+// to the OnListDiff callbacks
+func (s *listStore) triggerListDiff(key string, op ListOp, added, removed []Item) {
+	if !s.events.wants(eventListDiff, key) {
+		return
+	}
+	diff := &ListDiff{
+		Key: key,
+		Op:  op,
+	}
+	for _, i := range added {
+		diff.Added = append(diff.Added, copyItem(&i))
+	}
+	for _, i := range removed {
+		diff.Removed = append(diff.Removed, copyItem(&i))
 	}
+	s.events.publishListDiff(diff)
 }
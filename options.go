@@ -0,0 +1,169 @@
+package gostore
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// DefaultBTreeDegree is the degree of the btrees used by the indexes of a
+// store unless WithBTreeDegree is used
+const DefaultBTreeDegree = 32
+
+// DefaultSendTimeout is how long a call waits for an event loop to accept a
+// request unless WithSendTimeout is used
+const DefaultSendTimeout = 3 * time.Second
+
+// Logger receives the errors and warnings of a store. *log.Logger implements
+// Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Clock tells the time to a store. Expiry deadlines are computed and checked
+// using its time.
+type Clock interface {
+	Now() time.Time
+}
+
+// Option configures a store created with New
+type Option func(s *store)
+
+// settings are the options shared by the store and its event loops
+type settings struct {
+	tick        time.Duration // the longest the event loops wait to check for expired items, 0 for no limit
+	degree      int           // the degree of the btrees
+	sendTimeout time.Duration // how long to wait for an event loop to accept a request
+	logger      Logger
+	clock       Clock
+}
+
+func defaultSettings() *settings {
+	return &settings{
+		degree:      DefaultBTreeDegree,
+		sendTimeout: DefaultSendTimeout,
+		logger:      stdLogger{},
+		clock:       systemClock{},
+	}
+}
+
+func (o *settings) validate() error {
+	if o.tick < 0 {
+		return fmt.Errorf("invalid tick interval: %v", o.tick)
+	}
+	if o.degree < 2 {
+		return fmt.Errorf("invalid btree degree: %d", o.degree)
+	}
+	if o.sendTimeout <= 0 {
+		return fmt.Errorf("invalid send timeout: %v", o.sendTimeout)
+	}
+	if o.logger == nil || o.clock == nil {
+		return fmt.Errorf("nil logger or clock")
+	}
+	return nil
+}
+
+func (o *settings) now() time.Time {
+	return o.clock.Now()
+}
+
+func (o *settings) logf(format string, v ...interface{}) {
+	o.logger.Printf(format, v...)
+}
+
+// stdLogger writes to the standard logger of the log package
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// systemClock tells the time of the system
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// New returns a store configured by the options, ready to be used without
+// calling Init
+func New(opts ...Option) (Store, error) {
+	s := &store{
+		opts: defaultSettings(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if err := s.opts.validate(); err != nil {
+		return nil, err
+	}
+	if err := s.start(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// WithConfig replaces the configuration of the store. It should come before
+// the options that change parts of the configuration.
+func WithConfig(cfg Config) Option {
+	return func(s *store) {
+		s.cfg = cfg
+	}
+}
+
+// WithPersistence records every mutation in the append-only log at path,
+// which is replayed when the store is created
+func WithPersistence(path string, sync SyncPolicy) Option {
+	return func(s *store) {
+		s.cfg.LogPath = path
+		s.cfg.LogSync = sync
+	}
+}
+
+// WithLimits sets the MaxItems, MaxBytes and Eviction configuration
+func WithLimits(maxItems int, maxBytes int64, policy EvictionPolicy) Option {
+	return func(s *store) {
+		s.cfg.MaxItems = maxItems
+		s.cfg.MaxBytes = maxBytes
+		s.cfg.Eviction = policy
+	}
+}
+
+// WithTickInterval makes the event loops check for expired items at least
+// every d. By default they only wake up at the next deadline, which is enough
+// unless a Clock that does not follow the system time is used.
+func WithTickInterval(d time.Duration) Option {
+	return func(s *store) {
+		s.opts.tick = d
+	}
+}
+
+// WithBTreeDegree sets the degree of the btrees used by the indexes
+func WithBTreeDegree(degree int) Option {
+	return func(s *store) {
+		s.opts.degree = degree
+	}
+}
+
+// WithSendTimeout sets how long a call waits for an event loop to accept its
+// request before failing
+func WithSendTimeout(d time.Duration) Option {
+	return func(s *store) {
+		s.opts.sendTimeout = d
+	}
+}
+
+// WithLogger sends the errors and warnings of the store to l instead of the
+// standard logger
+func WithLogger(l Logger) Option {
+	return func(s *store) {
+		s.opts.logger = l
+	}
+}
+
+// WithClock makes the store tell the time with c instead of the system clock
+func WithClock(c Clock) Option {
+	return func(s *store) {
+		s.opts.clock = c
+	}
+}
@@ -0,0 +1,168 @@
+package gostore_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tonjun/gostore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type bufferLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *bufferLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *bufferLogger) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.lines...)
+}
+
+var _ = Describe("New", func() {
+
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "gostore")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("New() should return a store that does not need Init", func() {
+		store, err := gostore.New()
+		Expect(err).To(BeNil())
+		defer store.Close()
+
+		Expect(store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)).To(BeNil())
+		item, found, err := store.Get("k1")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(item.Value).To(Equal("v1"))
+	})
+
+	It("Init() should do nothing on a store returned by New or when called again", func() {
+		store, err := gostore.New()
+		Expect(err).To(BeNil())
+		defer store.Close()
+
+		Expect(store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)).To(BeNil())
+		store.Init()
+		store.Init()
+		_, found, err := store.Get("k1")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+	})
+
+	It("New() should reject invalid options", func() {
+		_, err := gostore.New(gostore.WithBTreeDegree(1))
+		Expect(err).NotTo(BeNil())
+		_, err = gostore.New(gostore.WithSendTimeout(0))
+		Expect(err).NotTo(BeNil())
+		_, err = gostore.New(gostore.WithTickInterval(-time.Second))
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("WithBTreeDegree() should keep the indexes ordered", func() {
+		store, err := gostore.New(gostore.WithBTreeDegree(2))
+		Expect(err).To(BeNil())
+		defer store.Close()
+
+		for i := 9; i >= 0; i-- {
+			key := fmt.Sprint("k", i)
+			store.Put(&gostore.Item{Key: key, ID: key, Value: i}, 0)
+			store.ZAdd("z", key, float64(i), nil)
+		}
+		keys, _ := store.Keys("*")
+		Expect(keys).To(HaveLen(10))
+		Expect(keys[0]).To(Equal("k0"))
+		m, _, _ := store.ZPopMin("z")
+		Expect(m.ID).To(Equal("k0"))
+	})
+
+	It("WithClock() and WithTickInterval() should expire items by the clock", func() {
+		clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		store, err := gostore.New(gostore.WithClock(clock), gostore.WithTickInterval(10*time.Millisecond))
+		Expect(err).To(BeNil())
+		defer store.Close()
+
+		expired := make(chan *gostore.Item, 10)
+		store.OnItemDidExpire(func(item *gostore.Item) {
+			expired <- item
+		})
+		store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, time.Hour)
+
+		ttl, _, _ := store.TTL("k1")
+		Expect(ttl).To(Equal(time.Hour))
+		Consistently(expired, 50*time.Millisecond).ShouldNot(Receive())
+
+		clock.Advance(2 * time.Hour)
+		Eventually(expired).Should(Receive())
+	})
+
+	It("WithPersistence() and WithLogger() should replay the log and report problems", func() {
+		path := filepath.Join(dir, "store.log")
+		store, err := gostore.New(gostore.WithPersistence(path, gostore.SyncAlways))
+		Expect(err).To(BeNil())
+		store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)
+		store.Close()
+
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		Expect(err).To(BeNil())
+		f.Write([]byte{1, 2, 3})
+		f.Close()
+
+		logger := &bufferLogger{}
+		store, err = gostore.New(gostore.WithPersistence(path, gostore.SyncAlways), gostore.WithLogger(logger))
+		Expect(err).To(BeNil())
+		defer store.Close()
+
+		_, found, _ := store.Get("k1")
+		Expect(found).To(BeTrue())
+		Expect(logger.Lines()).To(HaveLen(1))
+		Expect(logger.Lines()[0]).To(HavePrefix("WARNING: truncating"))
+	})
+
+	It("WithLimits() should limit the number of items", func() {
+		store, err := gostore.New(gostore.WithLimits(1, 0, gostore.NoEviction))
+		Expect(err).To(BeNil())
+		defer store.Close()
+
+		Expect(store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)).To(BeNil())
+		Expect(store.Put(&gostore.Item{Key: "k2", ID: "2", Value: "v2"}, 0)).To(Equal(gostore.ErrMemoryLimit))
+	})
+
+})
@@ -1,33 +1,306 @@
 package gostore
 
+import (
+	"context"
+	"time"
+)
+
+// writeCond is the condition of a conditional write
+type writeCond int
+
+const (
+	condNone   writeCond = iota // always write
+	condAbsent                  // write if there is no item for the key
+	condMatch                   // write if the item for the key has the expected ID
+)
+
 type setReq struct {
-	item Item
+	item  Item
+	cond  writeCond
+	match string
+	resp  chan error
+	ctx   context.Context
 }
 
 type getReq struct {
-	key      string
-	resp     chan Item
-	notFound chan bool
+	key  string
+	resp chan getResp
+}
+
+type getResp struct {
+	item  Item
+	found bool
 }
 
 type delReq struct {
-	key  string
-	resp chan bool
+	key   string
+	cond  writeCond
+	match string
+	resp  chan error
+	ctx   context.Context
 }
 
 type listPushReq struct {
 	key  string
 	item Item
+	resp chan error
+	ctx  context.Context
 }
 
 type listGetReq struct {
-	key      string
-	resp     chan []*Item
-	notFound chan bool
+	key  string
+	resp chan listGetResp
+}
+
+type listGetResp struct {
+	items []*Item
+	found bool
 }
 
 type listDelReq struct {
 	key  string
 	item Item
+	resp chan error
+	ctx  context.Context
+}
+
+type ttlOp int
+
+const (
+	ttlGet ttlOp = iota
+	ttlExpire
+	ttlExpireAt
+	ttlPersist
+	ttlTouch
+)
+
+type ttlReq struct {
+	op   ttlOp
+	key  string
+	d    time.Duration
+	at   time.Time
+	resp chan ttlResp
+}
+
+type ttlResp struct {
+	ttl   time.Duration
+	found bool
+	err   error
+}
+
+// holdReq pauses an event loop until release is closed
+type holdReq struct {
+	held    chan bool
+	release chan bool
+}
+
+type scanReq struct {
+	opts     ScanOptions
+	keysOnly bool
+	resp     chan scanResp
+}
+
+type scanResp struct {
+	keys  []string
+	items []Item
+	next  string
+	err   error
+}
+
+type listRangeReq struct {
+	key     string
+	from    string
+	limit   int
+	reverse bool
+	resp    chan listRangeResp
+}
+
+type listRangeResp struct {
+	items []Item
+	next  string
+}
+
+type listItemReq struct {
+	key  string
+	id   string
+	resp chan listItemResp
+}
+
+type listItemResp struct {
+	item  Item
+	found bool
+}
+
+type listLenReq struct {
+	key  string
+	resp chan int
+}
+
+type seqOp int
+
+const (
+	seqLPush seqOp = iota
+	seqRPush
+	seqLPop
+	seqRPop
+	seqIndex
+	seqRange
+	seqTrim
+	seqInsert
+	seqLen
+)
+
+type seqReq struct {
+	op    seqOp
+	key   string
+	items []Item
+	start int
+	stop  int
+	pivot string
+	after bool
+	resp  chan seqResp
+}
+
+type seqResp struct {
+	items []Item
+	n     int
+	found bool
+	err   error
+}
+
+// popReq is a BlockingPop request. It waits in the queues of its keys until a
+// member is pushed to one of them.
+type popReq struct {
+	keys []string
+	resp chan popResp // buffered so the loop never waits on it
+}
+
+type popResp struct {
+	key  string
+	item Item
+	err  error
+}
+
+type cancelPopReq struct {
+	req  *popReq
 	resp chan bool
 }
+
+type zsetOp int
+
+const (
+	zAdd zsetOp = iota
+	zIncrBy
+	zRangeByScore
+	zRank
+	zRem
+	zPopMin
+	zPopMax
+)
+
+type zsetReq struct {
+	op    zsetOp
+	key   string
+	item  Item
+	ids   []string
+	score float64
+	max   float64
+	resp  chan zsetResp
+}
+
+type zsetResp struct {
+	members []ZMember
+	score   float64
+	n       int
+	found   bool
+	err     error
+}
+
+// incrReq adds delta, or deltaFloat if float is set, to the number held by
+// the item for the key
+type incrReq struct {
+	key        string
+	delta      int64
+	deltaFloat float64
+	float      bool
+	ttl        time.Duration // the TTL of the item if it is created
+	resp       chan incrResp
+}
+
+type incrResp struct {
+	value interface{}
+	err   error
+}
+
+type setOp int
+
+const (
+	sAdd setOp = iota
+	sRem
+	sIsMember
+	sCard
+	sMembers
+	sInter
+	sUnion
+	sDiff
+)
+
+// stringSetReq is an operation on the sets for keys. The result of sInter,
+// sUnion and sDiff is saved under dest if it is not empty.
+type stringSetReq struct {
+	op      setOp
+	keys    []string
+	members []string
+	dest    string
+	resp    chan stringSetResp
+}
+
+type stringSetResp struct {
+	members []string
+	n       int
+	found   bool
+	err     error
+}
+
+// usageReq reads the approximate memory used by the key and by the store
+type usageReq struct {
+	key  string
+	resp chan usageResp
+}
+
+type usageResp struct {
+	count int   // the number of items or lists
+	bytes int64 // the memory used by all of them
+	size  int64 // the memory used by the key
+	found bool
+}
+
+type hashOp int
+
+const (
+	hSet hashOp = iota
+	hGet
+	hDel
+	hGetAll
+	hIncrBy
+	hLen
+	hKeys
+)
+
+type hashReq struct {
+	op     hashOp
+	key    string
+	fields []string
+	value  interface{}
+	delta  int64
+	resp   chan hashResp
+}
+
+type hashResp struct {
+	value  interface{}
+	fields map[string]interface{}
+	keys   []string
+	n      int64
+	found  bool
+	err    error
+}
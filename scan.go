@@ -0,0 +1,194 @@
+package gostore
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/btree"
+)
+
+// DefaultScanLimit is the page size used when ScanOptions.Limit is 0
+const DefaultScanLimit = 100
+
+// scanPageSize is the page size used by Range, Keys and Count
+const scanPageSize = 1000
+
+// ScanOptions select the items returned by Scan. Keys are visited in
+// lexicographic order.
+type ScanOptions struct {
+	// Prefix only selects keys starting with Prefix
+	Prefix string
+
+	// Start is the first key of the range, inclusive
+	Start string
+
+	// End is the end of the range, exclusive. The range is unbounded if End
+	// is empty.
+	End string
+
+	// Pattern only selects keys matching the glob pattern, using the syntax
+	// of path.Match
+	Pattern string
+
+	// Reverse visits the keys from the last to the first
+	Reverse bool
+
+	// Cursor continues the scan from the cursor returned by the previous page
+	Cursor string
+
+	// Limit is the maximum number of keys visited by a page. A page may
+	// return fewer items when Pattern is set.
+	Limit int
+}
+
+// keyItem is an entry in the ordered key index
+type keyItem string
+
+func (a keyItem) Less(b btree.Item) bool {
+	return a < b.(keyItem)
+}
+
+// scanKeys visits the keys selected by the options, at most Limit of them
+func (s *kvStore) scanKeys(r scanReq) scanResp {
+	opts := r.opts
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultScanLimit
+	}
+	if len(opts.Pattern) > 0 {
+		if _, err := path.Match(opts.Pattern, ""); err != nil {
+			return scanResp{err: err}
+		}
+	}
+
+	// lo is the inclusive lower bound and hi the exclusive upper bound
+	lo := opts.Start
+	if opts.Prefix > lo {
+		lo = opts.Prefix
+	}
+	hi := opts.End
+	if end := prefixEnd(opts.Prefix); len(end) > 0 && (len(hi) == 0 || end < hi) {
+		hi = end
+	}
+	if len(opts.Cursor) > 0 {
+		if opts.Reverse {
+			if len(hi) == 0 || opts.Cursor < hi {
+				hi = opts.Cursor
+			}
+		} else if c := opts.Cursor + "\x00"; c > lo {
+			lo = c
+		}
+	}
+
+	var resp scanResp
+	visited := 0
+	more := false
+	visit := func(a btree.Item) bool {
+		key := string(a.(keyItem))
+		if key < lo || (len(hi) > 0 && key >= hi) || !strings.HasPrefix(key, opts.Prefix) {
+			return false
+		}
+		if visited == limit {
+			more = true
+			return false
+		}
+		visited++
+		resp.next = key
+		if len(opts.Pattern) > 0 {
+			if ok, _ := path.Match(opts.Pattern, key); !ok {
+				return true
+			}
+		}
+		if r.keysOnly {
+			resp.keys = append(resp.keys, key)
+		} else {
+			resp.items = append(resp.items, s.kval[key].detached())
+		}
+		return true
+	}
+	if opts.Reverse {
+		if len(hi) == 0 {
+			s.keys.Descend(visit)
+		} else {
+			s.keys.DescendLessOrEqual(keyItem(hi), func(a btree.Item) bool {
+				if string(a.(keyItem)) == hi {
+					return true
+				}
+				return visit(a)
+			})
+		}
+	} else {
+		s.keys.AscendGreaterOrEqual(keyItem(lo), visit)
+	}
+	if !more {
+		resp.next = ""
+	}
+	return resp
+}
+
+// prefixEnd returns the first key greater than every key starting with
+// prefix, or "" if there is none
+func prefixEnd(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
+
+// scanPage returns a page of the keys or items selected by the options and the
+// cursor of the next page
+func (s *kvStore) scanPage(ctx context.Context, opts ScanOptions, keysOnly bool) (scanResp, error) {
+	req := scanReq{
+		opts:     opts,
+		keysOnly: keysOnly,
+		resp:     make(chan scanResp, 1),
+	}
+	if err := ctx.Err(); err != nil {
+		return scanResp{}, err
+	}
+	select {
+	case s.scan <- req:
+	case <-ctx.Done():
+		return scanResp{}, ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
+		return scanResp{}, fmt.Errorf("Scan channel timeout")
+	}
+	var r scanResp
+	select {
+	case r = <-req.resp:
+	case <-ctx.Done():
+		return scanResp{}, ctx.Err()
+	}
+	return r, r.err
+}
+
+// scanAll visits every page of the scan, calling fn with each of them
+func (s *kvStore) scanAll(ctx context.Context, opts ScanOptions, keysOnly bool, fn func(r scanResp)) error {
+	opts.Limit = scanPageSize
+	for {
+		r, err := s.scanPage(ctx, opts, keysOnly)
+		if err != nil {
+			return err
+		}
+		fn(r)
+		if len(r.next) == 0 {
+			return nil
+		}
+		opts.Cursor = r.next
+	}
+}
+
+// globPrefix returns the literal prefix of a glob pattern
+func globPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?[\\"); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
@@ -0,0 +1,108 @@
+package gostore_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tonjun/gostore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scan", func() {
+
+	var store gostore.Store
+
+	BeforeEach(func() {
+		store = gostore.NewStore()
+		store.Init()
+		for _, key := range []string{"user:1:profile", "user:1:session", "user:2:profile", "user:3:session", "other"} {
+			store.Put(&gostore.Item{Key: key, ID: "1", Value: key}, 0)
+		}
+	})
+
+	AfterEach(func() {
+		store.Close()
+	})
+
+	keysOf := func(items []*gostore.Item) []string {
+		keys := make([]string, 0, len(items))
+		for _, i := range items {
+			keys = append(keys, i.Key)
+		}
+		return keys
+	}
+
+	It("Scan() should page through the keys with a prefix in order", func() {
+		items, next, err := store.Scan(gostore.ScanOptions{Prefix: "user:", Limit: 3})
+		Expect(err).To(BeNil())
+		Expect(keysOf(items)).To(Equal([]string{"user:1:profile", "user:1:session", "user:2:profile"}))
+		Expect(next).NotTo(BeEmpty())
+
+		items, next, err = store.Scan(gostore.ScanOptions{Prefix: "user:", Limit: 3, Cursor: next})
+		Expect(err).To(BeNil())
+		Expect(keysOf(items)).To(Equal([]string{"user:3:session"}))
+		Expect(next).To(BeEmpty())
+	})
+
+	It("Scan() should end on the last page when it is full", func() {
+		items, next, _ := store.Scan(gostore.ScanOptions{Prefix: "user:1:", Limit: 2})
+		Expect(items).To(HaveLen(2))
+		Expect(next).To(BeEmpty())
+	})
+
+	It("Scan() should iterate in reverse", func() {
+		items, next, _ := store.Scan(gostore.ScanOptions{Prefix: "user:", Reverse: true, Limit: 2})
+		Expect(keysOf(items)).To(Equal([]string{"user:3:session", "user:2:profile"}))
+
+		items, next, _ = store.Scan(gostore.ScanOptions{Prefix: "user:", Reverse: true, Limit: 2, Cursor: next})
+		Expect(keysOf(items)).To(Equal([]string{"user:1:session", "user:1:profile"}))
+		Expect(next).To(BeEmpty())
+	})
+
+	It("Scan() should only return the keys matching the pattern", func() {
+		items, _, _ := store.Scan(gostore.ScanOptions{Pattern: "user:*:session"})
+		Expect(keysOf(items)).To(Equal([]string{"user:1:session", "user:3:session"}))
+	})
+
+	It("Range() should return the keys in the range", func() {
+		items, err := store.Range("user:1:session", "user:3")
+		Expect(err).To(BeNil())
+		Expect(keysOf(items)).To(Equal([]string{"user:1:session", "user:2:profile"}))
+
+		items, _ = store.Range("user:2", "")
+		Expect(keysOf(items)).To(Equal([]string{"user:2:profile", "user:3:session"}))
+	})
+
+	It("Keys() should return the keys matching the pattern", func() {
+		keys, err := store.Keys("user:*:profile")
+		Expect(err).To(BeNil())
+		Expect(keys).To(Equal([]string{"user:1:profile", "user:2:profile"}))
+
+		keys, _ = store.Keys("*")
+		Expect(keys).To(HaveLen(5))
+
+		_, err = store.Keys("[")
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("Count() should count the keys with a prefix across pages", func() {
+		for i := 0; i < 2500; i++ {
+			store.Put(&gostore.Item{Key: fmt.Sprintf("bulk:%05d", i), ID: "1", Value: i}, 0)
+		}
+		Expect(store.Count("bulk:")).To(Equal(2500))
+		Expect(store.Count("user:1:")).To(Equal(2))
+		Expect(store.Count("")).To(Equal(2505))
+	})
+
+	It("Deleted and expired keys should be removed from the index", func() {
+		store.Del("other")
+		store.Put(&gostore.Item{Key: "user:4:session", ID: "1", Value: "v"}, 10*time.Millisecond)
+		Expect(store.Count("user:4:")).To(Equal(1))
+		time.Sleep(20 * time.Millisecond)
+		Expect(store.Count("user:4:")).To(Equal(0))
+		Expect(store.Keys("other")).To(BeEmpty())
+	})
+
+})
@@ -0,0 +1,358 @@
+package gostore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// seqList is an insertion ordered list that allows duplicates. Every member
+// has a position that only changes when a member is inserted in the middle,
+// so changes can be recorded in the log by position.
+type seqList struct {
+	head  int64  // the position of the first member
+	buf   []Item // ring buffer of the members
+	start int    // index in buf of the first member
+	n     int
+}
+
+func newSeqList(head int64, items []Item) *seqList {
+	l := &seqList{head: head}
+	for _, i := range items {
+		l.pushBack(i)
+	}
+	return l
+}
+
+func (l *seqList) len() int {
+	return l.n
+}
+
+// at returns the member at index i, which must be in range
+func (l *seqList) at(i int) Item {
+	return l.buf[(l.start+i)%len(l.buf)]
+}
+
+// pos returns the position of the member at index i
+func (l *seqList) pos(i int) int64 {
+	return l.head + int64(i)
+}
+
+func (l *seqList) grow() {
+	if l.n < len(l.buf) {
+		return
+	}
+	size := 2 * len(l.buf)
+	if size == 0 {
+		size = 8
+	}
+	buf := make([]Item, size)
+	for i := 0; i < l.n; i++ {
+		buf[i] = l.at(i)
+	}
+	l.buf = buf
+	l.start = 0
+}
+
+func (l *seqList) pushFront(i Item) {
+	l.grow()
+	l.start = (l.start - 1 + len(l.buf)) % len(l.buf)
+	l.buf[l.start] = i
+	l.n++
+	l.head--
+}
+
+func (l *seqList) pushBack(i Item) {
+	l.grow()
+	l.buf[(l.start+l.n)%len(l.buf)] = i
+	l.n++
+}
+
+func (l *seqList) popFront() Item {
+	i := l.buf[l.start]
+	l.buf[l.start] = Item{}
+	l.start = (l.start + 1) % len(l.buf)
+	l.n--
+	l.head++
+	return i
+}
+
+func (l *seqList) popBack() Item {
+	idx := (l.start + l.n - 1) % len(l.buf)
+	i := l.buf[idx]
+	l.buf[idx] = Item{}
+	l.n--
+	return i
+}
+
+// slice returns a copy of the members from index start to stop, inclusive
+func (l *seqList) slice(start, stop int) []Item {
+	items := make([]Item, 0, stop-start+1)
+	for i := start; i <= stop; i++ {
+		items = append(items, l.at(i))
+	}
+	return items
+}
+
+// trim keeps the members from index start to stop, inclusive
+func (l *seqList) trim(start, stop int) {
+	for i := l.n - 1; i > stop; i-- {
+		l.popBack()
+	}
+	for i := 0; i < start; i++ {
+		l.popFront()
+	}
+}
+
+// insert adds the item before the member at index i
+func (l *seqList) insert(i int, item Item) {
+	items := l.slice(0, l.n-1)
+	items = append(items[:i], append([]Item{item}, items[i:]...)...)
+	*l = *newSeqList(l.head, items)
+}
+
+// index returns the index of the first member with the ID, or -1
+func (l *seqList) index(id string) int {
+	for i := 0; i < l.n; i++ {
+		if l.at(i).ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// normRange converts start and stop, which count from the end of a list of n
+// members when negative, to a range of indexes. ok is false if the range is
+// empty.
+func normRange(start, stop, n int) (int, int, bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+// InsertPosition tells LInsert where to insert relative to the pivot
+type InsertPosition int
+
+const (
+	// InsertBefore inserts the item before the pivot
+	InsertBefore InsertPosition = iota
+
+	// InsertAfter inserts the item after the pivot
+	InsertAfter
+)
+
+// applySeq runs an operation on an insertion ordered list
+func (s *listStore) applySeq(r seqReq) seqResp {
+	if err := s.checkKind(r.key, kindSeq); err != nil {
+		return seqResp{err: err}
+	}
+	l, ok := s.seqs[r.key]
+	if !ok && r.op != seqLPush && r.op != seqRPush {
+		return seqResp{}
+	}
+
+	switch r.op {
+	case seqLPush, seqRPush:
+		if !ok {
+			l = newSeqList(0, nil)
+		}
+		recs := make([]record, len(r.items))
+		for n := range r.items {
+			recs[n] = itemRecord(opSeqPut, r.key, &r.items[n])
+			if r.op == seqLPush {
+				recs[n].Pos = l.head - 1 - int64(n)
+			} else {
+				recs[n].Pos = l.head + int64(l.len()+n)
+			}
+		}
+		if err := s.wlog.append(batchRecord(recs)); err != nil {
+			return seqResp{err: err}
+		}
+		var size int64
+		for n := range r.items {
+			if r.op == seqLPush {
+				l.pushFront(r.items[n])
+			} else {
+				l.pushBack(r.items[n])
+			}
+			size += s.estimate.itemSize(&r.items[n])
+			s.events.publish(EventListPush, r.key, nil, &r.items[n])
+		}
+		s.seqs[r.key] = l
+		s.modified(r.key)
+		s.resize(r.key, size)
+		if r.op == seqLPush {
+			s.triggerListDiff(r.key, ListOpLPush, r.items, nil)
+		} else {
+			s.triggerListDiff(r.key, ListOpRPush, r.items, nil)
+		}
+		s.triggerListDidChange(r.key)
+		return seqResp{n: l.len()}
+
+	case seqLPop, seqRPop:
+		idx := 0
+		if r.op == seqRPop {
+			idx = l.len() - 1
+		}
+		if err := s.wlog.append(record{Op: opSeqDel, Key: r.key, Pos: l.pos(idx)}); err != nil {
+			return seqResp{err: err}
+		}
+		var i Item
+		if r.op == seqLPop {
+			i = l.popFront()
+		} else {
+			i = l.popBack()
+		}
+		if l.len() == 0 {
+			delete(s.seqs, r.key)
+		}
+		s.modified(r.key)
+		s.resize(r.key, -s.estimate.itemSize(&i))
+		s.events.publish(EventListDel, r.key, &i, nil)
+		if r.op == seqLPop {
+			s.triggerListDiff(r.key, ListOpLPop, nil, []Item{i})
+		} else {
+			s.triggerListDiff(r.key, ListOpRPop, nil, []Item{i})
+		}
+		s.triggerListDidChange(r.key)
+		return seqResp{items: []Item{i}, found: true}
+
+	case seqIndex:
+		idx := r.start
+		if idx < 0 {
+			idx += l.len()
+		}
+		if idx < 0 || idx >= l.len() {
+			return seqResp{}
+		}
+		return seqResp{items: []Item{l.at(idx)}, found: true}
+
+	case seqRange:
+		start, stop, ok := normRange(r.start, r.stop, l.len())
+		if !ok {
+			return seqResp{}
+		}
+		return seqResp{items: l.slice(start, stop)}
+
+	case seqTrim:
+		start, stop, ok := normRange(r.start, r.stop, l.len())
+		rec := record{Op: opSeqTrim, Key: r.key}
+		if ok {
+			rec.Pos = l.pos(start)
+			rec.Count = int64(stop - start + 1)
+		} else {
+			start, stop = l.len(), l.len()-1
+		}
+		if err := s.wlog.append(rec); err != nil {
+			return seqResp{err: err}
+		}
+		var removed []Item
+		if s.events.wants(EventListDel, r.key) || s.events.wants(eventListDiff, r.key) {
+			removed = append(l.slice(0, start-1), l.slice(stop+1, l.len()-1)...)
+		}
+		var size int64
+		trimmed := 0
+		for n := 0; n < l.len(); n++ {
+			if n < start || n > stop {
+				item := l.at(n)
+				size += s.estimate.itemSize(&item)
+				trimmed++
+			}
+		}
+		if ok {
+			l.trim(start, stop)
+		} else {
+			delete(s.seqs, r.key)
+		}
+		s.modified(r.key)
+		s.resize(r.key, -size)
+		for n := range removed {
+			s.events.publish(EventListDel, r.key, &removed[n], nil)
+		}
+		if trimmed > 0 {
+			s.triggerListDiff(r.key, ListOpTrim, nil, removed)
+			s.triggerListDidChange(r.key)
+		}
+		return seqResp{}
+
+	case seqInsert:
+		idx := l.index(r.pivot)
+		if idx < 0 {
+			return seqResp{n: -1}
+		}
+		if r.after {
+			idx++
+		}
+		item := r.items[0]
+		// the members from the position of the new one on move up by one
+		rec := itemRecord(opSeqInsert, r.key, &item)
+		rec.Pos = l.pos(idx)
+		if err := s.wlog.append(rec); err != nil {
+			return seqResp{err: err}
+		}
+		l.insert(idx, item)
+		s.modified(r.key)
+		s.resize(r.key, s.estimate.itemSize(&item))
+		s.events.publish(EventListPush, r.key, nil, &item)
+		s.triggerListDiff(r.key, ListOpInsert, []Item{item}, nil)
+		s.triggerListDidChange(r.key)
+		return seqResp{n: l.len()}
+
+	case seqLen:
+		return seqResp{n: l.len()}
+	}
+	return seqResp{}
+}
+
+// seq sends an operation on an insertion ordered list to the event loop
+func (s *listStore) seq(ctx context.Context, req seqReq) (seqResp, error) {
+	if len(req.key) == 0 {
+		return seqResp{}, fmt.Errorf("invalid input")
+	}
+	req.resp = make(chan seqResp, 1)
+	if err := ctx.Err(); err != nil {
+		return seqResp{}, err
+	}
+	select {
+	case s.lseq <- req:
+	case <-ctx.Done():
+		return seqResp{}, ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
+		return seqResp{}, fmt.Errorf("List channel timeout")
+	}
+	var r seqResp
+	select {
+	case r = <-req.resp:
+	case <-ctx.Done():
+		return seqResp{}, ctx.Err()
+	}
+	return r, r.err
+}
+
+// seqItems copies the values for a push or insert
+func seqItems(values []*Item, now time.Time) ([]Item, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("invalid input")
+	}
+	items := make([]Item, len(values))
+	for n, v := range values {
+		if v == nil {
+			return nil, fmt.Errorf("ERROR: nil value")
+		}
+		items[n] = newListItem(v, 0, now)
+	}
+	return items, nil
+}
@@ -0,0 +1,238 @@
+package gostore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// newMemberSet returns a set of the members
+func newMemberSet(members []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(members))
+	for _, m := range members {
+		set[m] = struct{}{}
+	}
+	return set
+}
+
+// setMembers returns the members of the set in lexicographic order
+func setMembers(set map[string]struct{}) []string {
+	members := make([]string, 0, len(set))
+	for m := range set {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// applySet runs an operation on the sets for the keys of the request
+func (s *kvStore) applySet(r stringSetReq, now time.Time) stringSetResp {
+	sets := make([]map[string]struct{}, len(r.keys))
+	for n, key := range r.keys {
+		if i, ok := s.kval[key]; ok && s.isExpired(i, now) {
+			s.expireItems(now)
+		}
+		if i, ok := s.kval[key]; ok {
+			if i.kind != kindSet {
+				return stringSetResp{err: ErrWrongKind}
+			}
+			sets[n] = i.members()
+		}
+	}
+	key := r.keys[0]
+	i, ok := s.kval[key]
+
+	switch r.op {
+	case sAdd:
+		var added []string
+		for _, m := range r.members {
+			if _, ok := sets[0][m]; !ok && !containsString(added, m) {
+				added = append(added, m)
+			}
+		}
+		if len(added) == 0 {
+			return stringSetResp{}
+		}
+		size := i.size
+		if !ok {
+			size = s.estimate.itemSize(&Item{Key: key})
+		}
+		for _, m := range added {
+			size += memberSize(m)
+		}
+		if err := s.makeRoom(key, size, now); err != nil {
+			return stringSetResp{err: err}
+		}
+		if err := s.wlog.append(record{Op: opSAdd, Key: key, Members: added}); err != nil {
+			return stringSetResp{err: err}
+		}
+		if !ok {
+			i = Item{Key: key, Value: make(map[string]struct{}), kind: kindSet}
+		}
+		for _, m := range added {
+			i.members()[m] = struct{}{}
+			s.events.publish(EventSAdd, key, nil, &Item{ID: m, Key: key})
+		}
+		s.modified(&i)
+		s.setItem(i)
+		return stringSetResp{n: len(added)}
+
+	case sRem:
+		var removed []string
+		for _, m := range r.members {
+			if _, ok := sets[0][m]; ok && !containsString(removed, m) {
+				removed = append(removed, m)
+			}
+		}
+		if len(removed) == 0 {
+			return stringSetResp{}
+		}
+		if err := s.wlog.append(record{Op: opSRem, Key: key, Members: removed}); err != nil {
+			return stringSetResp{err: err}
+		}
+		for _, m := range removed {
+			delete(i.members(), m)
+			s.events.publish(EventSRem, key, &Item{ID: m, Key: key}, nil)
+		}
+		if len(i.members()) == 0 {
+			s.deleteItem(key)
+			s.events.publish(EventDel, key, &i, nil)
+		} else {
+			s.modified(&i)
+			s.setItem(i)
+		}
+		return stringSetResp{n: len(removed)}
+
+	case sIsMember, sCard, sMembers:
+		if !ok {
+			return stringSetResp{}
+		}
+		s.accessed(&i, now)
+		switch r.op {
+		case sIsMember:
+			_, found := sets[0][r.members[0]]
+			return stringSetResp{found: found}
+		case sCard:
+			return stringSetResp{n: len(sets[0])}
+		}
+		return stringSetResp{members: setMembers(sets[0])}
+	}
+
+	result := make(map[string]struct{})
+	switch r.op {
+	case sInter:
+		for m := range sets[0] {
+			inAll := true
+			for _, set := range sets[1:] {
+				if _, ok := set[m]; !ok {
+					inAll = false
+					break
+				}
+			}
+			if inAll {
+				result[m] = struct{}{}
+			}
+		}
+	case sUnion:
+		for _, set := range sets {
+			for m := range set {
+				result[m] = struct{}{}
+			}
+		}
+	case sDiff:
+		for m := range sets[0] {
+			inOther := false
+			for _, set := range sets[1:] {
+				if _, ok := set[m]; ok {
+					inOther = true
+					break
+				}
+			}
+			if !inOther {
+				result[m] = struct{}{}
+			}
+		}
+	}
+	if len(r.dest) == 0 {
+		return stringSetResp{members: setMembers(result)}
+	}
+	return s.storeSet(r.dest, result, now)
+}
+
+// storeSet replaces the item for the key with the set, or deletes it if the
+// set is empty, and returns the size of the set
+func (s *kvStore) storeSet(key string, set map[string]struct{}, now time.Time) stringSetResp {
+	if i, ok := s.kval[key]; ok && s.isExpired(i, now) {
+		s.expireItems(now)
+	}
+	old, exists := s.kval[key]
+	if len(set) == 0 {
+		if exists {
+			if err := s.wlog.append(record{Op: opDel, Key: key}); err != nil {
+				return stringSetResp{err: err}
+			}
+			s.deleteItem(key)
+			s.events.publish(EventDel, key, &old, nil)
+		}
+		return stringSetResp{}
+	}
+	i := Item{Key: key, Value: set, kind: kindSet}
+	if err := s.makeRoom(key, s.estimate.itemSize(&i), now); err != nil {
+		return stringSetResp{err: err}
+	}
+	if err := s.wlog.append(itemRecord(opPut, key, &i)); err != nil {
+		return stringSetResp{err: err}
+	}
+	s.modified(&i)
+	s.setItem(i)
+	if s.events.wants(EventOverwrite|EventPut, key) {
+		// later changes to the set must not change the published item
+		copied := i.detached()
+		if exists {
+			s.events.publish(EventOverwrite, key, &old, &copied)
+		} else {
+			s.events.publish(EventPut, key, nil, &copied)
+		}
+	}
+	return stringSetResp{n: len(set)}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// runSet sends an operation on sets to the event loop
+func (s *kvStore) runSet(ctx context.Context, req stringSetReq) (stringSetResp, error) {
+	if len(req.keys) == 0 {
+		return stringSetResp{}, fmt.Errorf("invalid input")
+	}
+	for _, key := range req.keys {
+		if len(key) == 0 {
+			return stringSetResp{}, fmt.Errorf("invalid input")
+		}
+	}
+	req.resp = make(chan stringSetResp, 1)
+	if err := ctx.Err(); err != nil {
+		return stringSetResp{}, err
+	}
+	select {
+	case s.sets <- req:
+	case <-ctx.Done():
+		return stringSetResp{}, ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
+		return stringSetResp{}, fmt.Errorf("Set channel timeout")
+	}
+	var r stringSetResp
+	select {
+	case r = <-req.resp:
+	case <-ctx.Done():
+		return stringSetResp{}, ctx.Err()
+	}
+	return r, r.err
+}
@@ -0,0 +1,146 @@
+package gostore_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/tonjun/gostore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sets", func() {
+
+	var store gostore.Store
+
+	BeforeEach(func() {
+		store = gostore.NewStore()
+		store.Init()
+	})
+
+	AfterEach(func() {
+		store.Close()
+	})
+
+	It("SAdd() and SRem() should add and remove unique members", func() {
+		Expect(store.SAdd("tags", "go", "db", "go")).To(Equal(2))
+		Expect(store.SAdd("tags", "db", "kv")).To(Equal(1))
+		Expect(store.SCard("tags")).To(Equal(3))
+		Expect(store.SMembers("tags")).To(Equal([]string{"db", "go", "kv"}))
+
+		Expect(store.SIsMember("tags", "go")).To(BeTrue())
+		Expect(store.SIsMember("tags", "rust")).To(BeFalse())
+
+		Expect(store.SRem("tags", "go", "rust")).To(Equal(1))
+		Expect(store.SMembers("tags")).To(Equal([]string{"db", "kv"}))
+
+		Expect(store.SRem("tags", "db", "kv")).To(Equal(2))
+		Expect(store.Keys("*")).To(BeEmpty())
+		Expect(store.SMembers("tags")).To(BeEmpty())
+	})
+
+	It("SInter(), SUnion() and SDiff() should combine the sets", func() {
+		store.SAdd("a", "1", "2", "3")
+		store.SAdd("b", "2", "3", "4")
+		store.SAdd("c", "3", "5")
+
+		Expect(store.SInter("a", "b", "c")).To(Equal([]string{"3"}))
+		Expect(store.SUnion("a", "b", "c")).To(Equal([]string{"1", "2", "3", "4", "5"}))
+		Expect(store.SDiff("a", "b")).To(Equal([]string{"1"}))
+		Expect(store.SDiff("a", "c", "missing")).To(Equal([]string{"1", "2"}))
+		Expect(store.SInter("a", "missing")).To(BeEmpty())
+	})
+
+	It("The *Store variants should save the result under a new key", func() {
+		store.SAdd("a", "1", "2", "3")
+		store.SAdd("b", "2", "3", "4")
+
+		Expect(store.SInterStore("dest", "a", "b")).To(Equal(2))
+		Expect(store.SMembers("dest")).To(Equal([]string{"2", "3"}))
+
+		Expect(store.SUnionStore("dest", "a", "b")).To(Equal(4))
+		Expect(store.SCard("dest")).To(Equal(4))
+
+		// the result is a copy of the sources
+		store.SAdd("a", "9")
+		Expect(store.SIsMember("dest", "9")).To(BeFalse())
+
+		Expect(store.SDiffStore("a", "a", "b")).To(Equal(2))
+		Expect(store.SMembers("a")).To(Equal([]string{"1", "9"}))
+
+		Expect(store.SInterStore("dest", "a", "missing")).To(Equal(0))
+		_, err := store.SCard("dest")
+		Expect(err).To(BeNil())
+		Expect(store.Keys("dest")).To(BeEmpty())
+	})
+
+	It("Sets should not share a key with other items", func() {
+		store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)
+		_, err := store.SAdd("k1", "a")
+		Expect(err).To(Equal(gostore.ErrWrongKind))
+		_, err = store.SUnion("k1", "s")
+		Expect(err).To(Equal(gostore.ErrWrongKind))
+
+		store.SAdd("s", "a")
+		_, _, err = store.Get("s")
+		Expect(err).To(Equal(gostore.ErrWrongKind))
+		_, err = store.HSet("s", "f", "v")
+		Expect(err).To(Equal(gostore.ErrWrongKind))
+	})
+
+	It("Watch() should report every member change", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events := store.Watch(ctx, gostore.EventFilter{Kinds: gostore.EventSAdd | gostore.EventSRem})
+
+		store.SAdd("s", "a")
+		store.SRem("s", "a")
+
+		var e gostore.Event
+		Eventually(events).Should(Receive(&e))
+		Expect(e.Kind).To(Equal(gostore.EventSAdd))
+		Expect(e.Key).To(Equal("s"))
+		Expect(e.New.ID).To(Equal("a"))
+
+		Eventually(events).Should(Receive(&e))
+		Expect(e.Kind).To(Equal(gostore.EventSRem))
+		Expect(e.Old.ID).To(Equal("a"))
+	})
+
+	It("Sets should be saved in snapshots", func() {
+		store.SAdd("s", "a", "b")
+
+		var buf bytes.Buffer
+		Expect(store.Snapshot(&buf)).To(BeNil())
+		store.SRem("s", "a")
+		Expect(store.Restore(&buf)).To(BeNil())
+
+		Expect(store.SMembers("s")).To(Equal([]string{"a", "b"}))
+	})
+
+	It("Sets should be replayed from the log", func() {
+		dir, err := ioutil.TempDir("", "gostore")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		cfg := gostore.Config{LogPath: filepath.Join(dir, "store.log"), LogSync: gostore.SyncAlways}
+
+		logged := gostore.NewStoreWithConfig(cfg)
+		logged.Init()
+		logged.SAdd("a", "1", "2", "3")
+		logged.SAdd("b", "2")
+		logged.SRem("a", "3")
+		logged.SDiffStore("c", "a", "b")
+		logged.Close()
+
+		logged = gostore.NewStoreWithConfig(cfg)
+		logged.Init()
+		defer logged.Close()
+		Expect(logged.SMembers("a")).To(Equal([]string{"1", "2"}))
+		Expect(logged.SMembers("c")).To(Equal([]string{"1"}))
+	})
+
+})
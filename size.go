@@ -0,0 +1,152 @@
+package gostore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Sizer is implemented by values that report the approximate memory they use
+// in bytes
+type Sizer interface {
+	Size() int64
+}
+
+// SizeEstimator returns the approximate memory used by a value in bytes. It
+// is called for the values that do not implement Sizer.
+type SizeEstimator func(value interface{}) int64
+
+// DefaultSizeEstimator counts the length of strings and byte slices and the
+// size of bools and numbers. Values implementing Sizer report their own size
+// and other values count as a pointer.
+func DefaultSizeEstimator(value interface{}) int64 {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case Sizer:
+		return v.Size()
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	case bool, int8, uint8:
+		return 1
+	case int16, uint16:
+		return 2
+	case int32, uint32, float32:
+		return 4
+	}
+	return 8
+}
+
+// Stats are the totals reported by Stats
+type Stats struct {
+	Items     int   // the number of key/value items, including hashes, sets and counters
+	ItemBytes int64 // the approximate memory used by the key/value items
+	Lists     int   // the number of lists of every kind
+	ListBytes int64 // the approximate memory used by the lists
+	Bytes     int64 // the approximate memory used by the store
+}
+
+// itemOverhead approximates the memory used by an item besides its key, ID
+// and value
+const itemOverhead = 64
+
+// entryOverhead approximates the memory used by a field of a hash or a member
+// of a set besides its name and value
+const entryOverhead = 16
+
+// listOverhead approximates the memory used by a list besides its key and
+// members
+const listOverhead = 64
+
+// valueSize estimates the size of the value
+func (e SizeEstimator) valueSize(value interface{}) int64 {
+	if s, ok := value.(Sizer); ok {
+		return s.Size()
+	}
+	if e == nil {
+		return DefaultSizeEstimator(value)
+	}
+	return e(value)
+}
+
+// itemSize approximates the memory used by the item
+func (e SizeEstimator) itemSize(i *Item) int64 {
+	n := itemOverhead + int64(len(i.Key)+len(i.ID))
+	switch i.kind {
+	case kindHash:
+		for f, v := range i.fields() {
+			n += e.fieldSize(f, v)
+		}
+	case kindSet:
+		for m := range i.members() {
+			n += memberSize(m)
+		}
+	default:
+		n += e.valueSize(i.Value)
+	}
+	return n
+}
+
+// fieldSize approximates the memory used by a field of a hash
+func (e SizeEstimator) fieldSize(field string, value interface{}) int64 {
+	return entryOverhead + int64(len(field)) + e.valueSize(value)
+}
+
+// memberSize approximates the memory used by a member of a set
+func memberSize(member string) int64 {
+	return entryOverhead + int64(len(member))
+}
+
+// memoryUsage returns the approximate memory used by the item for the key and
+// by all the items
+func (s *kvStore) memoryUsage(ctx context.Context, key string) (usageResp, error) {
+	req := usageReq{
+		key:  key,
+		resp: make(chan usageResp, 1),
+	}
+	if err := ctx.Err(); err != nil {
+		return usageResp{}, err
+	}
+	select {
+	case s.usage <- req:
+	case <-ctx.Done():
+		return usageResp{}, ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
+		return usageResp{}, fmt.Errorf("Usage channel timeout")
+	}
+	var r usageResp
+	select {
+	case r = <-req.resp:
+	case <-ctx.Done():
+		return usageResp{}, ctx.Err()
+	}
+	return r, nil
+}
+
+// memoryUsage returns the approximate memory used by the list for the key and
+// by all the lists
+func (s *listStore) memoryUsage(ctx context.Context, key string) (usageResp, error) {
+	req := usageReq{
+		key:  key,
+		resp: make(chan usageResp, 1),
+	}
+	if err := ctx.Err(); err != nil {
+		return usageResp{}, err
+	}
+	select {
+	case s.lusage <- req:
+	case <-ctx.Done():
+		return usageResp{}, ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
+		return usageResp{}, fmt.Errorf("Usage channel timeout")
+	}
+	var r usageResp
+	select {
+	case r = <-req.resp:
+	case <-ctx.Done():
+		return usageResp{}, ctx.Err()
+	}
+	return r, nil
+}
@@ -0,0 +1,112 @@
+package gostore_test
+
+import (
+	"strings"
+
+	"github.com/tonjun/gostore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type blob struct {
+	n int64
+}
+
+func (b blob) Size() int64 {
+	return b.n
+}
+
+var _ = Describe("Memory usage", func() {
+
+	var store gostore.Store
+
+	BeforeEach(func() {
+		store = gostore.NewStore()
+		store.Init()
+	})
+
+	AfterEach(func() {
+		store.Close()
+	})
+
+	usage := func(key string) int64 {
+		n, _, err := store.MemoryUsage(key)
+		Expect(err).To(BeNil())
+		return n
+	}
+
+	It("MemoryUsage() should count the key, ID and value of an item", func() {
+		store.Put(&gostore.Item{Key: "k1", ID: "1", Value: strings.Repeat("x", 100)}, 0)
+		store.Put(&gostore.Item{Key: "k2", ID: "2", Value: []byte("abc")}, 0)
+		store.Put(&gostore.Item{Key: "k3", ID: "3", Value: blob{n: 1000}}, 0)
+
+		small := usage("k2")
+		Expect(usage("k1")).To(Equal(small + 97))
+		Expect(usage("k3")).To(Equal(small + 997))
+
+		_, found, _ := store.MemoryUsage("missing")
+		Expect(found).To(BeFalse())
+	})
+
+	It("MemoryUsage() should follow the changes to hashes, sets and lists", func() {
+		store.HSet("h", "f1", "v")
+		before := usage("h")
+		store.HSet("h", "f2", strings.Repeat("x", 100))
+		Expect(usage("h")).To(BeNumerically(">", before+100))
+		store.HDel("h", "f2")
+		Expect(usage("h")).To(Equal(before))
+
+		store.RPush("l", &gostore.Item{Value: "a"})
+		before = usage("l")
+		store.RPush("l", &gostore.Item{Value: strings.Repeat("x", 100)})
+		Expect(usage("l")).To(BeNumerically(">", before+100))
+		store.RPop("l")
+		Expect(usage("l")).To(Equal(before))
+	})
+
+	It("Stats() should report the totals of the items and lists", func() {
+		stats, err := store.Stats()
+		Expect(err).To(BeNil())
+		Expect(stats).To(Equal(gostore.Stats{}))
+
+		store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)
+		store.SAdd("s", "a", "b")
+		store.ListPush("l1", &gostore.Item{ID: "a", Value: "a data"})
+		store.LPush("l2", &gostore.Item{Value: "a"})
+		store.ZAdd("z", "a", 1, nil)
+
+		stats, _ = store.Stats()
+		Expect(stats.Items).To(Equal(2))
+		Expect(stats.Lists).To(Equal(3))
+		Expect(stats.ItemBytes).To(Equal(usage("k1") + usage("s")))
+		Expect(stats.ListBytes).To(Equal(usage("l1") + usage("l2") + usage("z")))
+		Expect(stats.Bytes).To(Equal(stats.ItemBytes + stats.ListBytes))
+
+		store.Del("k1")
+		store.SRem("s", "a", "b")
+		store.ListDel("l1", &gostore.Item{ID: "a"})
+		store.LPop("l2")
+		store.ZPopMin("z")
+
+		stats, _ = store.Stats()
+		Expect(stats).To(Equal(gostore.Stats{}))
+	})
+
+	It("SizeEstimator should replace the default estimator", func() {
+		custom := gostore.NewStoreWithConfig(gostore.Config{
+			SizeEstimator: func(value interface{}) int64 {
+				return 1000
+			},
+		})
+		custom.Init()
+		defer custom.Close()
+
+		custom.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v"}, 0)
+		custom.Put(&gostore.Item{Key: "k2", ID: "2", Value: blob{n: 10}}, 0)
+		n1, _, _ := custom.MemoryUsage("k1")
+		n2, _, _ := custom.MemoryUsage("k2")
+		Expect(n1 - n2).To(Equal(int64(990)))
+	})
+
+})
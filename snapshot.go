@@ -0,0 +1,414 @@
+package gostore
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const snapshotVersion = 1
+
+type recordOp int
+
+const (
+	opEnd recordOp = iota
+	opPut
+	opDel
+	opListPush
+	opListDel
+	opBatch
+	opSeqPut     // set the member of an insertion ordered list at Pos
+	opSeqDel     // remove the member of an insertion ordered list at Pos
+	opSeqTrim    // keep the Count members of an insertion ordered list from Pos
+	opSeqReplace // replace an insertion ordered list with the Batch from Pos, from older logs
+	opZAdd       // set the score and value of a sorted set member
+	opZRem       // remove a sorted set member
+	opHSet       // set the field ID of a hash to Value
+	opHDel       // remove the field ID of a hash
+	opSAdd       // add Members to a set
+	opSRem       // remove Members from a set
+	opSeqInsert  // insert a member into an insertion ordered list at Pos
+	opReplace    // replace the whole contents of the store with the Batch
+)
+
+// record is the serialized form of a store entry
+type record struct {
+	Op         recordOp
+	Key        string
+	ID         string
+	Value      interface{}
+	ExpiresAt  time.Time
+	TTL        time.Duration
+	Idle       time.Duration
+	AccessedAt time.Time // when an item with an idle timeout was last read
+	Batch      []record  // the records of an opBatch, applied together
+	Pos        int64     // the position of a member of an insertion ordered list
+	Count      int64
+	Score      float64                // the score of a sorted set member
+	Fields     map[string]interface{} // the fields of a hash
+	Members    []string               // the members of a set
+}
+
+// listContents is the contents of the list store
+type listContents struct {
+	lists map[string][]Item
+	seqs  map[string]seqDump
+	zsets map[string][]zsetMember
+}
+
+// seqDump is the contents of an insertion ordered list
+type seqDump struct {
+	Head  int64
+	Items []Item
+}
+
+// itemRecord returns a record that stores the item under key
+func itemRecord(op recordOp, key string, i *Item) record {
+	rec := record{
+		Op:        op,
+		Key:       key,
+		ID:        i.ID,
+		Value:     i.Value,
+		ExpiresAt: i.deadline,
+		TTL:       i.ttl,
+		Idle:      i.idle,
+	}
+	if i.idle > 0 {
+		rec.AccessedAt = i.accessedAt
+	}
+	if i.kind == kindHash {
+		rec.Value = nil
+		rec.Fields = i.fields()
+	} else if i.kind == kindSet {
+		rec.Value = nil
+		rec.Members = setMembers(i.members())
+	}
+	return rec
+}
+
+type snapshotHeader struct {
+	Version int
+	Created time.Time
+}
+
+func (r *record) item() Item {
+	i := Item{
+		ID:       r.ID,
+		Key:      r.Key,
+		Value:    r.Value,
+		deadline: r.ExpiresAt,
+		ttl:      r.TTL,
+		idle:     r.Idle,
+	}
+	i.accessedAt = r.AccessedAt
+	i.updateExpiry()
+	if r.Fields != nil {
+		i.kind = kindHash
+		i.Value = r.Fields
+		i = i.detached()
+	} else if r.Members != nil {
+		i.kind = kindSet
+		i.Value = newMemberSet(r.Members)
+	}
+	return i
+}
+
+// restoreAccess starts the idle timeout of an item whose record does not say
+// when it was last read at now
+func restoreAccess(i *Item, now time.Time) {
+	if i.idle > 0 && i.accessedAt.IsZero() {
+		i.access(now)
+	}
+}
+
+// storeState is a decoded copy of the store contents
+type storeState struct {
+	items map[string]Item
+	lists map[string]map[string]Item
+	seqs  map[string]map[int64]Item
+	zsets map[string]map[string]zsetMember
+}
+
+func newStoreState() *storeState {
+	return &storeState{
+		items: make(map[string]Item),
+		lists: make(map[string]map[string]Item),
+		seqs:  make(map[string]map[int64]Item),
+		zsets: make(map[string]map[string]zsetMember),
+	}
+}
+
+func (st *storeState) seq(key string) map[int64]Item {
+	l, ok := st.seqs[key]
+	if !ok {
+		l = make(map[int64]Item)
+		st.seqs[key] = l
+	}
+	return l
+}
+
+// apply updates the state with the record
+func (st *storeState) apply(r *record) error {
+	switch r.Op {
+	case opPut:
+		st.items[r.Key] = r.item()
+	case opDel:
+		delete(st.items, r.Key)
+	case opListPush:
+		l, ok := st.lists[r.Key]
+		if !ok {
+			l = make(map[string]Item)
+			st.lists[r.Key] = l
+		}
+		l[r.ID] = r.item()
+	case opListDel:
+		delete(st.lists[r.Key], r.ID)
+	case opBatch:
+		for i := range r.Batch {
+			if err := st.apply(&r.Batch[i]); err != nil {
+				return err
+			}
+		}
+	case opReplace:
+		*st = *newStoreState()
+		for i := range r.Batch {
+			if err := st.apply(&r.Batch[i]); err != nil {
+				return err
+			}
+		}
+	case opSeqPut:
+		st.seq(r.Key)[r.Pos] = r.item()
+	case opSeqDel:
+		delete(st.seq(r.Key), r.Pos)
+	case opSeqTrim:
+		l := st.seq(r.Key)
+		for pos := range l {
+			if pos < r.Pos || pos >= r.Pos+r.Count {
+				delete(l, pos)
+			}
+		}
+	case opSeqInsert:
+		l := st.seq(r.Key)
+		positions := make(seqPositions, 0, len(l))
+		for pos := range l {
+			if pos >= r.Pos {
+				positions = append(positions, pos)
+			}
+		}
+		sort.Sort(sort.Reverse(positions))
+		for _, pos := range positions {
+			l[pos+1] = l[pos]
+			delete(l, pos)
+		}
+		l[r.Pos] = r.item()
+	case opSeqReplace:
+		l := make(map[int64]Item, len(r.Batch))
+		for n := range r.Batch {
+			l[r.Pos+int64(n)] = r.Batch[n].item()
+		}
+		st.seqs[r.Key] = l
+	case opHSet:
+		i, ok := st.items[r.Key]
+		if !ok || i.kind != kindHash {
+			i = Item{Key: r.Key, kind: kindHash, Value: make(map[string]interface{})}
+			st.items[r.Key] = i
+		}
+		i.fields()[r.ID] = r.Value
+	case opHDel:
+		if i, ok := st.items[r.Key]; ok && i.kind == kindHash {
+			delete(i.fields(), r.ID)
+			if len(i.fields()) == 0 {
+				delete(st.items, r.Key)
+			}
+		}
+	case opSAdd:
+		i, ok := st.items[r.Key]
+		if !ok || i.kind != kindSet {
+			i = Item{Key: r.Key, kind: kindSet, Value: make(map[string]struct{})}
+			st.items[r.Key] = i
+		}
+		for _, m := range r.Members {
+			i.members()[m] = struct{}{}
+		}
+	case opSRem:
+		if i, ok := st.items[r.Key]; ok && i.kind == kindSet {
+			for _, m := range r.Members {
+				delete(i.members(), m)
+			}
+			if len(i.members()) == 0 {
+				delete(st.items, r.Key)
+			}
+		}
+	case opZAdd:
+		z, ok := st.zsets[r.Key]
+		if !ok {
+			z = make(map[string]zsetMember)
+			st.zsets[r.Key] = z
+		}
+		z[r.ID] = zsetMember{score: r.Score, item: r.item()}
+	case opZRem:
+		delete(st.zsets[r.Key], r.ID)
+	default:
+		return fmt.Errorf("invalid record: %d", r.Op)
+	}
+	return nil
+}
+
+// contents returns the items and list members that have not expired by now.
+// The idle timeout of an item runs from the time it was last read, or from
+// now for records written before that time was saved.
+func (st *storeState) contents(now time.Time) (items []Item, lc listContents) {
+	for _, i := range st.items {
+		restoreAccess(&i, now)
+		if i.expiresAt.IsZero() || i.expiresAt.After(now) {
+			items = append(items, i)
+		}
+	}
+	lc.lists = make(map[string][]Item, len(st.lists))
+	for key, l := range st.lists {
+		for _, i := range l {
+			restoreAccess(&i, now)
+			if i.expiresAt.IsZero() || i.expiresAt.After(now) {
+				lc.lists[key] = append(lc.lists[key], i)
+			}
+		}
+	}
+	lc.seqs = make(map[string]seqDump, len(st.seqs))
+	for key, l := range st.seqs {
+		if len(l) == 0 {
+			continue
+		}
+		positions := make(seqPositions, 0, len(l))
+		for pos := range l {
+			positions = append(positions, pos)
+		}
+		sort.Sort(positions)
+		d := seqDump{Head: positions[0]}
+		for _, pos := range positions {
+			d.Items = append(d.Items, l[pos])
+		}
+		lc.seqs[key] = d
+	}
+	lc.zsets = make(map[string][]zsetMember, len(st.zsets))
+	for key, z := range st.zsets {
+		for _, m := range z {
+			lc.zsets[key] = append(lc.zsets[key], m)
+		}
+	}
+	return items, lc
+}
+
+type seqPositions []int64
+
+func (p seqPositions) Len() int           { return len(p) }
+func (p seqPositions) Less(i, j int) bool { return p[i] < p[j] }
+func (p seqPositions) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// batchRecord returns a single record applying all of recs
+func batchRecord(recs []record) record {
+	if len(recs) == 1 {
+		return recs[0]
+	}
+	return record{Op: opBatch, Batch: recs}
+}
+
+// snapshotRecords converts the store contents to opPut, opListPush, opSeqPut
+// and opZAdd records
+func snapshotRecords(items []Item, lc listContents) []record {
+	recs := make([]record, 0, len(items))
+	for i := range items {
+		recs = append(recs, itemRecord(opPut, items[i].Key, &items[i]))
+	}
+	for key, l := range lc.lists {
+		for i := range l {
+			recs = append(recs, itemRecord(opListPush, key, &l[i]))
+		}
+	}
+	for key, d := range lc.seqs {
+		for i := range d.Items {
+			rec := itemRecord(opSeqPut, key, &d.Items[i])
+			rec.Pos = d.Head + int64(i)
+			recs = append(recs, rec)
+		}
+	}
+	for key, z := range lc.zsets {
+		for i := range z {
+			recs = append(recs, z[i].record(key))
+		}
+	}
+	return recs
+}
+
+// writeSnapshot encodes the records to w. Values are encoded using
+// encoding/gob, so custom Value types must be registered with gob.Register.
+func writeSnapshot(w io.Writer, recs []record, now time.Time) error {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(snapshotHeader{Version: snapshotVersion, Created: now}); err != nil {
+		return err
+	}
+	for i := range recs {
+		if err := enc.Encode(&recs[i]); err != nil {
+			return err
+		}
+	}
+	return enc.Encode(record{Op: opEnd})
+}
+
+// readSnapshot decodes a snapshot written by writeSnapshot. Items that have
+// expired by now are skipped.
+func readSnapshot(r io.Reader, now time.Time) (items []Item, lc listContents, err error) {
+	dec := gob.NewDecoder(r)
+	var h snapshotHeader
+	if err := dec.Decode(&h); err != nil {
+		return nil, lc, fmt.Errorf("invalid snapshot header: %v", err)
+	}
+	if h.Version != snapshotVersion {
+		return nil, lc, fmt.Errorf("unsupported snapshot version: %d", h.Version)
+	}
+	st := newStoreState()
+	for {
+		var rec record
+		if err := dec.Decode(&rec); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, lc, fmt.Errorf("invalid snapshot: %v", err)
+		}
+		if rec.Op == opEnd {
+			items, lc = st.contents(now)
+			return items, lc, nil
+		}
+		if err := st.apply(&rec); err != nil {
+			return nil, lc, fmt.Errorf("invalid snapshot: %v", err)
+		}
+	}
+}
+
+// writeFileAtomic writes to a temporary file in the same directory as path
+// and renames it over path once fn succeeds
+func writeFileAtomic(path string, fn func(w io.Writer) error) error {
+	f, err := os.Create(filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp"))
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err := fn(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
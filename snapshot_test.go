@@ -0,0 +1,177 @@
+package gostore_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tonjun/gostore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Snapshot", func() {
+
+	var store gostore.Store
+	var restored gostore.Store
+
+	BeforeEach(func() {
+		store = gostore.NewStore()
+		store.Init()
+		restored = gostore.NewStore()
+		restored.Init()
+	})
+
+	AfterEach(func() {
+		store.Close()
+		restored.Close()
+	})
+
+	It("Restore() should recreate the items and lists saved by Snapshot()", func() {
+		Expect(store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)).To(BeNil())
+		Expect(store.Put(&gostore.Item{Key: "k2", ID: "2", Value: 2}, 0)).To(BeNil())
+		Expect(store.ListPush("l1", &gostore.Item{ID: "a", Value: "a data"})).To(BeNil())
+		Expect(store.ListPush("l1", &gostore.Item{ID: "b", Value: "b data"})).To(BeNil())
+
+		var buf bytes.Buffer
+		Expect(store.Snapshot(&buf)).To(BeNil())
+		Expect(restored.Restore(&buf)).To(BeNil())
+
+		i, found, err := restored.Get("k1")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(i.ID).To(Equal("1"))
+		Expect(i.Value).To(Equal("v1"))
+
+		i, found, err = restored.Get("k2")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(i.Value).To(Equal(2))
+
+		items, found, err := restored.ListGet("l1")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(len(items)).To(Equal(2))
+		if len(items) == 2 {
+			Expect(items[0].ID).To(Equal("a"))
+			Expect(items[1].ID).To(Equal("b"))
+			Expect(items[1].Value).To(Equal("b data"))
+		}
+	})
+
+	It("Restore() should replace the existing contents of the store", func() {
+		Expect(store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)).To(BeNil())
+		Expect(restored.Put(&gostore.Item{Key: "old", ID: "1", Value: "old"}, 0)).To(BeNil())
+
+		var buf bytes.Buffer
+		Expect(store.Snapshot(&buf)).To(BeNil())
+		Expect(restored.Restore(&buf)).To(BeNil())
+
+		_, found, err := restored.Get("old")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeFalse())
+	})
+
+	It("Restored items should keep their remaining TTL", func() {
+		ch := make(chan string, 1)
+		restored.OnItemDidExpire(func(item *gostore.Item) {
+			ch <- item.Key
+		})
+		Expect(store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 700*time.Millisecond)).To(BeNil())
+
+		var buf bytes.Buffer
+		Expect(store.Snapshot(&buf)).To(BeNil())
+		Expect(restored.Restore(&buf)).To(BeNil())
+
+		_, found, _ := restored.Get("k1")
+		Expect(found).To(BeTrue())
+		Eventually(ch, "2s").Should(Receive(Equal("k1")))
+	})
+
+	It("Restore() should skip items that expired after the snapshot was taken", func() {
+		Expect(store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 100*time.Millisecond)).To(BeNil())
+		Expect(store.Put(&gostore.Item{Key: "k2", ID: "2", Value: "v2"}, 0)).To(BeNil())
+
+		var buf bytes.Buffer
+		Expect(store.Snapshot(&buf)).To(BeNil())
+		time.Sleep(200 * time.Millisecond)
+		Expect(restored.Restore(&buf)).To(BeNil())
+
+		_, found, _ := restored.Get("k1")
+		Expect(found).To(BeFalse())
+		_, found, _ = restored.Get("k2")
+		Expect(found).To(BeTrue())
+	})
+
+	It("Snapshot() should never capture part of a batch", func() {
+		done := make(chan bool)
+		go func() {
+			defer close(done)
+			for i := 1; i <= 200; i++ {
+				b := gostore.NewBatch().
+					Put(&gostore.Item{Key: "n", ID: "n", Value: i}, 0).
+					ListPush("l", &gostore.Item{ID: fmt.Sprintf("%03d", i)})
+				Expect(store.Apply(b)).To(BeNil())
+			}
+		}()
+
+		for running := true; running; {
+			select {
+			case <-done:
+				running = false
+			default:
+			}
+			var buf bytes.Buffer
+			Expect(store.Snapshot(&buf)).To(BeNil())
+			Expect(restored.Restore(&buf)).To(BeNil())
+			n := 0
+			if i, found, _ := restored.Get("n"); found {
+				n = i.Value.(int)
+			}
+			count, _ := restored.ListLen("l")
+			Expect(count).To(Equal(n))
+		}
+	})
+
+	It("Restore() should skip items that went idle after the snapshot was taken", func() {
+		opts := gostore.PutOptions{IdleTimeout: 100 * time.Millisecond}
+		Expect(store.PutWithOptions(&gostore.Item{Key: "session", ID: "1", Value: "data"}, opts)).To(BeNil())
+
+		var buf bytes.Buffer
+		Expect(store.Snapshot(&buf)).To(BeNil())
+		time.Sleep(200 * time.Millisecond)
+		Expect(restored.Restore(&buf)).To(BeNil())
+
+		_, found, _ := restored.Get("session")
+		Expect(found).To(BeFalse())
+	})
+
+	It("Restore() should fail on a truncated snapshot", func() {
+		Expect(store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)).To(BeNil())
+
+		var buf bytes.Buffer
+		Expect(store.Snapshot(&buf)).To(BeNil())
+		Expect(restored.Restore(bytes.NewReader(buf.Bytes()[:buf.Len()-4]))).ToNot(BeNil())
+	})
+
+	It("SnapshotFile() and RestoreFile() should round trip through a file", func() {
+		dir, err := ioutil.TempDir("", "gostore")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "store.snap")
+
+		Expect(store.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v1"}, 0)).To(BeNil())
+		Expect(store.SnapshotFile(path)).To(BeNil())
+		Expect(restored.RestoreFile(path)).To(BeNil())
+
+		i, found, err := restored.Get("k1")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(i.Value).To(Equal("v1"))
+	})
+
+})
@@ -0,0 +1,155 @@
+package gostore
+
+import (
+	"context"
+	"errors"
+)
+
+// DefaultTxnRetries is the number of retries used when Config.TxnRetries is 0
+const DefaultTxnRetries = 10
+
+// ErrTxnConflict is returned by Txn when a watched key or list kept changing
+// until the retries were exhausted
+var ErrTxnConflict = errors.New("transaction conflict: a watched key changed")
+
+// Txn is an optimistic transaction. Keys and lists are watched with Watch and
+// WatchList before they are read, and the changes are queued with the
+// operations of the embedded Batch. The changes are applied when the
+// function passed to Store.Txn returns, only if none of the watched keys and
+// lists changed in the meantime.
+type Txn struct {
+	Batch
+	s     *store
+	ctx   context.Context
+	keys  map[string]uint64
+	lists map[string]uint64
+}
+
+// Watch watches the key/value items for the keys. A key that does not exist
+// is watched too and conflicts if it is created.
+func (t *Txn) Watch(keys ...string) error {
+	release, err := t.s.kv.pause(t.ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	t.s.kv.expireItems(t.s.opts.now())
+	for _, key := range keys {
+		if _, ok := t.keys[key]; !ok {
+			t.keys[key] = t.s.kv.watch(key)
+		}
+	}
+	return nil
+}
+
+// WatchList watches the lists for the keys
+func (t *Txn) WatchList(keys ...string) error {
+	release, err := t.s.ls.pause(t.ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	t.s.ls.expireItems(t.s.opts.now())
+	for _, key := range keys {
+		if _, ok := t.lists[key]; !ok {
+			t.lists[key] = t.s.ls.watch(key)
+		}
+	}
+	return nil
+}
+
+// Get returns the item given the key
+func (t *Txn) Get(key string) (*Item, bool, error) {
+	return t.GetCtx(context.Background(), key)
+}
+
+// GetCtx is like Get and honours ctx like Store.GetCtx
+func (t *Txn) GetCtx(ctx context.Context, key string) (*Item, bool, error) {
+	return t.s.kv.getItem(ctx, key)
+}
+
+// ListGet returns the list of items given a key
+func (t *Txn) ListGet(key string) ([]*Item, bool, error) {
+	return t.ListGetCtx(context.Background(), key)
+}
+
+// ListGetCtx is like ListGet and honours ctx like Store.GetCtx
+func (t *Txn) ListGetCtx(ctx context.Context, key string) ([]*Item, bool, error) {
+	return t.s.ls.listGet(ctx, key)
+}
+
+// changed returns ErrTxnConflict if a watched key or list has changed, and
+// stops watching them. It must be called while both event loops are paused.
+func (t *Txn) changed() error {
+	defer t.unwatch()
+	for key, v := range t.keys {
+		if t.s.kv.versionOf(key) != v {
+			return ErrTxnConflict
+		}
+	}
+	for key, v := range t.lists {
+		if t.s.ls.versionOf(key) != v {
+			return ErrTxnConflict
+		}
+	}
+	return nil
+}
+
+// unwatch stops watching the keys and lists. It must be called while both
+// event loops are paused.
+func (t *Txn) unwatch() {
+	for key := range t.keys {
+		t.s.kv.unwatch(key)
+	}
+	for key := range t.lists {
+		t.s.ls.unwatch(key)
+	}
+	t.keys = make(map[string]uint64)
+	t.lists = make(map[string]uint64)
+}
+
+// release stops watching the keys and lists of a transaction that was not
+// committed
+func (t *Txn) release() {
+	if len(t.keys) == 0 && len(t.lists) == 0 {
+		return
+	}
+	release, err := t.s.pauseAll(context.Background())
+	if err != nil {
+		t.s.opts.logf("ERROR: unable to release the watched keys: %v", err)
+		return
+	}
+	t.unwatch()
+	release()
+}
+
+// runTxn runs fn and commits its transaction, running it again on a conflict
+// until ctx is done
+func (s *store) runTxn(ctx context.Context, fn func(tx *Txn) error) error {
+	retries := s.cfg.TxnRetries
+	if retries <= 0 {
+		retries = DefaultTxnRetries
+	}
+	for n := 0; ; n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		tx := &Txn{
+			s:     s,
+			ctx:   ctx,
+			keys:  make(map[string]uint64),
+			lists: make(map[string]uint64),
+		}
+		if err := fn(tx); err != nil {
+			tx.release()
+			return err
+		}
+		err := s.applyBatch(ctx, &tx.Batch, tx.changed)
+		tx.release()
+		if err != ErrTxnConflict || n >= retries {
+			return err
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package gostore_test
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tonjun/gostore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Txn", func() {
+
+	var store gostore.Store
+
+	BeforeEach(func() {
+		store = gostore.NewStoreWithConfig(gostore.Config{TxnRetries: 100})
+		store.Init()
+	})
+
+	AfterEach(func() {
+		store.Close()
+	})
+
+	incr := func(key string) error {
+		return store.Txn(func(tx *gostore.Txn) error {
+			if err := tx.Watch(key); err != nil {
+				return err
+			}
+			n := 0
+			if item, found, _ := tx.Get(key); found {
+				n = item.Value.(int)
+			}
+			tx.Put(&gostore.Item{Key: key, ID: fmt.Sprint(n + 1), Value: n + 1}, 0)
+			return nil
+		})
+	}
+
+	It("Txn() should apply the queued operations", func() {
+		Expect(incr("counter")).To(BeNil())
+		Expect(incr("counter")).To(BeNil())
+
+		item, _, _ := store.Get("counter")
+		Expect(item.Value).To(Equal(2))
+	})
+
+	It("Concurrent transactions should not lose updates", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(incr("counter")).To(BeNil())
+			}()
+		}
+		wg.Wait()
+
+		item, _, _ := store.Get("counter")
+		Expect(item.Value).To(Equal(10))
+	})
+
+	It("Txn() should run the function again when a watched key is created and deleted", func() {
+		runs := 0
+		err := store.Txn(func(tx *gostore.Txn) error {
+			runs++
+			tx.Watch("k1")
+			if runs == 1 {
+				store.Put(&gostore.Item{Key: "k1", ID: "other", Value: "other"}, 0)
+				store.Del("k1")
+			}
+			tx.Put(&gostore.Item{Key: "k2", ID: "1", Value: runs}, 0)
+			return nil
+		})
+		Expect(err).To(BeNil())
+		Expect(runs).To(Equal(2))
+	})
+
+	It("Txn() should not conflict on deletions of other keys while it watches a missing key", func() {
+		store.Put(&gostore.Item{Key: "other", ID: "1", Value: "v"}, 0)
+		store.Put(&gostore.Item{Key: "short", ID: "1", Value: "v"}, time.Millisecond)
+
+		runs := 0
+		err := store.Txn(func(tx *gostore.Txn) error {
+			runs++
+			tx.Watch("k1")
+			store.Del("other")
+			time.Sleep(5 * time.Millisecond)
+			store.Get("short")
+			tx.Put(&gostore.Item{Key: "k1", ID: "1", Value: runs}, 0)
+			return nil
+		})
+		Expect(err).To(BeNil())
+		Expect(runs).To(Equal(1))
+	})
+
+	It("Txn() should run the function again when a watched list is emptied and pushed to again", func() {
+		store.ListPush("l1", &gostore.Item{ID: "a", Value: "a data"})
+
+		runs := 0
+		err := store.Txn(func(tx *gostore.Txn) error {
+			runs++
+			tx.WatchList("l1")
+			if runs == 1 {
+				store.ListDel("l1", &gostore.Item{ID: "a"})
+				store.ListPush("l1", &gostore.Item{ID: "a", Value: "a data"})
+			}
+			tx.Put(&gostore.Item{Key: "k1", ID: "1", Value: runs}, 0)
+			return nil
+		})
+		Expect(err).To(BeNil())
+		Expect(runs).To(Equal(2))
+
+		_, found, _ := store.ListGet("l1")
+		Expect(found).To(BeTrue())
+		store.ListDel("l1", &gostore.Item{ID: "a"})
+		_, found, _ = store.ListGet("l1")
+		Expect(found).To(BeFalse())
+	})
+
+	It("Txn() should run the function again when a watched key changes", func() {
+		runs := 0
+		err := store.Txn(func(tx *gostore.Txn) error {
+			runs++
+			tx.Watch("k1")
+			if runs == 1 {
+				store.Put(&gostore.Item{Key: "k1", ID: "other", Value: "other"}, 0)
+			}
+			tx.Put(&gostore.Item{Key: "k2", ID: "1", Value: runs}, 0)
+			return nil
+		})
+		Expect(err).To(BeNil())
+		Expect(runs).To(Equal(2))
+
+		item, _, _ := store.Get("k2")
+		Expect(item.Value).To(Equal(2))
+	})
+
+	It("Txn() should detect changes to a watched list", func() {
+		store.ListPush("l1", &gostore.Item{ID: "a", Value: "a data"})
+
+		runs := 0
+		err := store.Txn(func(tx *gostore.Txn) error {
+			runs++
+			tx.WatchList("l1")
+			if runs == 1 {
+				store.ListDel("l1", &gostore.Item{ID: "a"})
+			}
+			items, _, _ := tx.ListGet("l1")
+			tx.Put(&gostore.Item{Key: "len", ID: "1", Value: len(items)}, 0)
+			return nil
+		})
+		Expect(err).To(BeNil())
+		Expect(runs).To(Equal(2))
+
+		item, _, _ := store.Get("len")
+		Expect(item.Value).To(Equal(0))
+	})
+
+	It("Txn() should return ErrTxnConflict once the retries are exhausted", func() {
+		limited := gostore.NewStoreWithConfig(gostore.Config{TxnRetries: 2})
+		limited.Init()
+		defer limited.Close()
+
+		runs := 0
+		err := limited.Txn(func(tx *gostore.Txn) error {
+			runs++
+			tx.Watch("k1")
+			limited.Put(&gostore.Item{Key: "k1", ID: fmt.Sprint(runs), Value: runs}, 0)
+			tx.Put(&gostore.Item{Key: "k2", ID: "1", Value: "v"}, 0)
+			return nil
+		})
+		Expect(err).To(Equal(gostore.ErrTxnConflict))
+		Expect(runs).To(Equal(3))
+
+		_, found, _ := limited.Get("k2")
+		Expect(found).To(BeFalse())
+	})
+
+	It("Txn() should not apply anything if the function fails", func() {
+		err := store.Txn(func(tx *gostore.Txn) error {
+			tx.Put(&gostore.Item{Key: "k1", ID: "1", Value: "v"}, 0)
+			return fmt.Errorf("failed")
+		})
+		Expect(err).To(MatchError("failed"))
+
+		_, found, _ := store.Get("k1")
+		Expect(found).To(BeFalse())
+	})
+
+})
@@ -0,0 +1,249 @@
+package gostore
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/btree"
+)
+
+// ZMember is a member of a sorted set
+type ZMember struct {
+	ID    string
+	Score float64
+	Value interface{}
+}
+
+// zsetEntry orders the members of a sorted set by score and then by ID
+type zsetEntry struct {
+	score float64
+	id    string
+}
+
+func (a zsetEntry) Less(b btree.Item) bool {
+	e := b.(zsetEntry)
+	if a.score != e.score {
+		return a.score < e.score
+	}
+	return a.id < e.id
+}
+
+type zsetMember struct {
+	score float64
+	item  Item
+}
+
+func (m *zsetMember) member() ZMember {
+	return ZMember{ID: m.item.ID, Score: m.score, Value: m.item.Value}
+}
+
+func (m *zsetMember) record(key string) record {
+	rec := itemRecord(opZAdd, key, &m.item)
+	rec.Score = m.score
+	return rec
+}
+
+// zset is a sorted set, indexed by ID and ordered by score
+type zset struct {
+	byScore *btree.BTree
+	members map[string]zsetMember
+}
+
+func newZSet(degree int) *zset {
+	return &zset{
+		byScore: btree.New(degree),
+		members: make(map[string]zsetMember),
+	}
+}
+
+// add adds or replaces the member and returns true if it is new
+func (z *zset) add(m zsetMember) bool {
+	old, ok := z.members[m.item.ID]
+	if ok {
+		z.byScore.Delete(zsetEntry{score: old.score, id: old.item.ID})
+	}
+	z.members[m.item.ID] = m
+	z.byScore.ReplaceOrInsert(zsetEntry{score: m.score, id: m.item.ID})
+	return !ok
+}
+
+func (z *zset) remove(id string) (zsetMember, bool) {
+	m, ok := z.members[id]
+	if !ok {
+		return m, false
+	}
+	z.byScore.Delete(zsetEntry{score: m.score, id: id})
+	delete(z.members, id)
+	return m, true
+}
+
+func (z *zset) len() int {
+	return len(z.members)
+}
+
+// all returns the members in order
+func (z *zset) all() []zsetMember {
+	members := make([]zsetMember, 0, z.len())
+	z.byScore.Ascend(func(a btree.Item) bool {
+		members = append(members, z.members[a.(zsetEntry).id])
+		return true
+	})
+	return members
+}
+
+// applyZSet runs an operation on a sorted set
+func (s *listStore) applyZSet(r zsetReq) zsetResp {
+	if err := s.checkKind(r.key, kindZSet); err != nil {
+		return zsetResp{err: err}
+	}
+	z, ok := s.zsets[r.key]
+	if !ok && r.op != zAdd && r.op != zIncrBy {
+		return zsetResp{}
+	}
+
+	switch r.op {
+	case zAdd, zIncrBy:
+		if !ok {
+			z = newZSet(s.opts.degree)
+		}
+		m := zsetMember{score: r.score, item: r.item}
+		old, exists := z.members[r.item.ID]
+		if exists && r.op == zIncrBy {
+			m = old
+			m.score += r.score
+		}
+		if math.IsNaN(m.score) {
+			return zsetResp{err: fmt.Errorf("invalid score")}
+		}
+		if err := s.wlog.append(m.record(r.key)); err != nil {
+			return zsetResp{err: err}
+		}
+		added := z.add(m)
+		s.zsets[r.key] = z
+		delta := s.memberSize(&m)
+		var oldItem *Item
+		if exists {
+			delta -= s.memberSize(&old)
+			oldItem = &old.item
+		}
+		s.events.publish(EventListPush, r.key, oldItem, &m.item)
+		s.zsetChanged(r.key, delta)
+		return zsetResp{score: m.score, found: added}
+
+	case zRangeByScore:
+		var members []ZMember
+		z.byScore.AscendGreaterOrEqual(zsetEntry{score: r.score}, func(a btree.Item) bool {
+			e := a.(zsetEntry)
+			if e.score > r.max {
+				return false
+			}
+			m := z.members[e.id]
+			members = append(members, m.member())
+			return true
+		})
+		return zsetResp{members: members}
+
+	case zRank:
+		m, ok := z.members[r.item.ID]
+		if !ok {
+			return zsetResp{}
+		}
+		n := 0
+		z.byScore.AscendLessThan(zsetEntry{score: m.score, id: m.item.ID}, func(a btree.Item) bool {
+			n++
+			return true
+		})
+		return zsetResp{n: n, found: true}
+
+	case zRem:
+		var recs []record
+		for _, id := range r.ids {
+			if _, ok := z.members[id]; ok {
+				recs = append(recs, record{Op: opZRem, Key: r.key, ID: id})
+			}
+		}
+		if len(recs) == 0 {
+			return zsetResp{}
+		}
+		if err := s.wlog.append(batchRecord(recs)); err != nil {
+			return zsetResp{err: err}
+		}
+		var delta int64
+		for _, rec := range recs {
+			m, _ := z.remove(rec.ID)
+			delta -= s.memberSize(&m)
+			s.events.publish(EventListDel, r.key, &m.item, nil)
+		}
+		s.zsetChanged(r.key, delta)
+		return zsetResp{n: len(recs)}
+
+	case zPopMin, zPopMax:
+		var e btree.Item
+		if r.op == zPopMin {
+			e = z.byScore.Min()
+		} else {
+			e = z.byScore.Max()
+		}
+		id := e.(zsetEntry).id
+		if err := s.wlog.append(record{Op: opZRem, Key: r.key, ID: id}); err != nil {
+			return zsetResp{err: err}
+		}
+		m, _ := z.remove(id)
+		s.events.publish(EventListDel, r.key, &m.item, nil)
+		s.zsetChanged(r.key, -s.memberSize(&m))
+		return zsetResp{members: []ZMember{m.member()}, found: true}
+	}
+	return zsetResp{}
+}
+
+// zsetChanged removes the sorted set if it is empty, adds delta to its size
+// and notifies the OnZSetDidChange callbacks
+func (s *listStore) zsetChanged(key string, delta int64) {
+	if s.zsets[key].len() == 0 {
+		delete(s.zsets, key)
+	}
+	s.modified(key)
+	s.resize(key, delta)
+	if !s.events.wants(eventZSetChange, key) {
+		return
+	}
+	var members []ZMember
+	if z, ok := s.zsets[key]; ok {
+		for _, m := range z.all() {
+			members = append(members, m.member())
+		}
+	}
+	s.events.publishZSetChange(key, members)
+}
+
+// memberSize approximates the memory used by a member of a sorted set
+func (s *listStore) memberSize(m *zsetMember) int64 {
+	return s.estimate.itemSize(&m.item) + 8
+}
+
+// zset sends an operation on a sorted set to the event loop
+func (s *listStore) zset(ctx context.Context, req zsetReq) (zsetResp, error) {
+	if len(req.key) == 0 {
+		return zsetResp{}, fmt.Errorf("invalid input")
+	}
+	req.resp = make(chan zsetResp, 1)
+	if err := ctx.Err(); err != nil {
+		return zsetResp{}, err
+	}
+	select {
+	case s.lzset <- req:
+	case <-ctx.Done():
+		return zsetResp{}, ctx.Err()
+	case <-time.After(s.opts.sendTimeout):
+		return zsetResp{}, fmt.Errorf("Sorted set channel timeout")
+	}
+	var r zsetResp
+	select {
+	case r = <-req.resp:
+	case <-ctx.Done():
+		return zsetResp{}, ctx.Err()
+	}
+	return r, r.err
+}
@@ -0,0 +1,168 @@
+package gostore_test
+
+import (
+	"bytes"
+	"context"
+	"math"
+
+	"github.com/tonjun/gostore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sorted sets", func() {
+
+	var store gostore.Store
+
+	BeforeEach(func() {
+		store = gostore.NewStore()
+		store.Init()
+	})
+
+	AfterEach(func() {
+		store.Close()
+	})
+
+	idsOf := func(members []gostore.ZMember) []string {
+		ids := make([]string, 0, len(members))
+		for _, m := range members {
+			ids = append(ids, m.ID)
+		}
+		return ids
+	}
+
+	It("ZAdd() should order the members by numeric score", func() {
+		Expect(store.ZAdd("board", "alice", 10, "a")).To(BeTrue())
+		Expect(store.ZAdd("board", "bob", 9, "b")).To(BeTrue())
+		Expect(store.ZAdd("board", "carol", 100, "c")).To(BeTrue())
+		Expect(store.ZAdd("board", "bob", 50, "b2")).To(BeFalse())
+
+		members, err := store.ZRangeByScore("board", math.Inf(-1), math.Inf(1))
+		Expect(err).To(BeNil())
+		Expect(idsOf(members)).To(Equal([]string{"alice", "bob", "carol"}))
+		Expect(members[1].Score).To(Equal(50.0))
+		Expect(members[1].Value).To(Equal("b2"))
+
+		members, _ = store.ZRangeByScore("board", 10, 50)
+		Expect(idsOf(members)).To(Equal([]string{"alice", "bob"}))
+	})
+
+	It("Members with the same score should be ordered by ID", func() {
+		store.ZAdd("z", "b", 1, nil)
+		store.ZAdd("z", "a", 1, nil)
+		store.ZAdd("z", "c", 0, nil)
+
+		members, _ := store.ZRangeByScore("z", 0, 1)
+		Expect(idsOf(members)).To(Equal([]string{"c", "a", "b"}))
+	})
+
+	It("ZIncrBy() should add to the score, creating the member", func() {
+		Expect(store.ZIncrBy("z", "a", 5)).To(Equal(5.0))
+		Expect(store.ZIncrBy("z", "a", -1.5)).To(Equal(3.5))
+
+		_, err := store.ZIncrBy("z", "a", math.NaN())
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("ZRank() should return the index of the member", func() {
+		store.ZAdd("z", "a", 3, nil)
+		store.ZAdd("z", "b", 1, nil)
+		store.ZAdd("z", "c", 2, nil)
+
+		rank, found, err := store.ZRank("z", "a")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(rank).To(Equal(2))
+
+		_, found, _ = store.ZRank("z", "missing")
+		Expect(found).To(BeFalse())
+	})
+
+	It("ZRem(), ZPopMin() and ZPopMax() should remove members", func() {
+		for i, id := range []string{"a", "b", "c", "d"} {
+			store.ZAdd("z", id, float64(i), nil)
+		}
+		Expect(store.ZRem("z", "b", "missing")).To(Equal(1))
+
+		m, found, err := store.ZPopMin("z")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(m.ID).To(Equal("a"))
+
+		m, _, _ = store.ZPopMax("z")
+		Expect(m.ID).To(Equal("d"))
+
+		m, _, _ = store.ZPopMax("z")
+		Expect(m.ID).To(Equal("c"))
+
+		_, found, _ = store.ZPopMin("z")
+		Expect(found).To(BeFalse())
+	})
+
+	It("OnZSetDidChange() should receive the contents after every change", func() {
+		changes := make(chan []gostore.ZMember, 10)
+		store.OnZSetDidChange(func(key string, members []gostore.ZMember) {
+			changes <- members
+		})
+
+		store.ZAdd("z", "a", 2, nil)
+		store.ZAdd("z", "b", 1, nil)
+		store.ZRem("z", "a")
+
+		var members []gostore.ZMember
+		Eventually(changes).Should(Receive(&members))
+		Expect(idsOf(members)).To(Equal([]string{"a"}))
+		Eventually(changes).Should(Receive(&members))
+		Expect(idsOf(members)).To(Equal([]string{"b", "a"}))
+		Eventually(changes).Should(Receive(&members))
+		Expect(idsOf(members)).To(Equal([]string{"b"}))
+	})
+
+	It("Watch() should receive the members added to and removed from sorted sets", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events := store.Watch(ctx, gostore.EventFilter{})
+
+		store.ZAdd("z", "a", 1, "a data")
+		store.ZAdd("z", "a", 2, "a data 2")
+		store.ZRem("z", "a")
+
+		var e gostore.Event
+		Eventually(events).Should(Receive(&e))
+		Expect(e.Kind).To(Equal(gostore.EventListPush))
+		Expect(e.Key).To(Equal("z"))
+		Expect(e.Old).To(BeNil())
+		Expect(e.New.ID).To(Equal("a"))
+
+		Eventually(events).Should(Receive(&e))
+		Expect(e.Kind).To(Equal(gostore.EventListPush))
+		Expect(e.Old.Value).To(Equal("a data"))
+		Expect(e.New.Value).To(Equal("a data 2"))
+
+		Eventually(events).Should(Receive(&e))
+		Expect(e.Kind).To(Equal(gostore.EventListDel))
+		Expect(e.Old.ID).To(Equal("a"))
+	})
+
+	It("Sorted sets should not share a key with lists", func() {
+		store.RPush("q", &gostore.Item{Value: "a"})
+		_, err := store.ZAdd("q", "a", 1, nil)
+		Expect(err).To(Equal(gostore.ErrWrongKind))
+	})
+
+	It("Sorted sets should be saved in snapshots", func() {
+		store.ZAdd("z", "a", 2, "va")
+		store.ZAdd("z", "b", 1, "vb")
+
+		var buf bytes.Buffer
+		Expect(store.Snapshot(&buf)).To(BeNil())
+		store.ZRem("z", "a", "b")
+		Expect(store.Restore(&buf)).To(BeNil())
+
+		members, _ := store.ZRangeByScore("z", 0, 10)
+		Expect(idsOf(members)).To(Equal([]string{"b", "a"}))
+		Expect(members[1].Value).To(Equal("va"))
+	})
+
+})